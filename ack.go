@@ -3,11 +3,7 @@ package ant
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
-	"fmt"
 	"log"
-	"strconv"
-	"strings"
 	"time"
 
 	bot "github.com/MixinNetwork/bot-api-go-client"
@@ -15,9 +11,8 @@ import (
 )
 
 const (
-	SubcribedUser = "subcriberd_user"
-	OceanWebsite  = "https://mixcoin.one"
-	ExinWebsite   = "https://exinone.com/#/exchange/flash/flashTakeOrder?uuid=%d"
+	OceanWebsite = "https://mixcoin.one"
+	ExinWebsite  = "https://exinone.com/#/exchange/flash/flashTakeOrder?uuid=%d"
 )
 
 var PairIndex = map[string]int{
@@ -39,76 +34,59 @@ func (ant *Ant) OnMessage(ctx context.Context, msgView bot.MessageView, userId s
 			return err
 		}
 		log.Println("I got a message, it said: ", string(data))
-		switch strings.ToLower(string(data)) {
-		case "whoisyourdaddy":
-			assets, err := ReadAssets(ctx)
-			if err != nil {
-				return err
-			}
-			out := make(map[string]string, 0)
-			for asset, balance := range assets {
-				if amount, _ := strconv.ParseFloat(balance, 64); amount > 0.0 {
-					out[Who(asset)] = balance
-				}
-			}
-			bt, err := json.Marshal(out)
-			if err != nil {
-				return err
-			}
-			return ant.client.SendPlainText(ctx, msgView, string(bt))
-		case "sub":
-			if _, err := Redis(ctx).SAdd(SubcribedUser, msgView.UserId).Result(); err != nil {
-				log.Println("Add user err", err)
-			}
-			ant.client.SendPlainText(ctx, msgView, "Thanks for your attention.\n You may get a notification if you can benefit from the price differences below.")
-			ocean := bot.Button{Label: "Mixcoin", Action: OceanWebsite, Color: "#2e8b57"}
-			exin := bot.Button{Label: "ExinOne", Action: fmt.Sprintf(ExinWebsite, 15), Color: "#bc8f8f"}
-			return ant.client.SendAppButtons(ctx, msgView.ConversationId, msgView.UserId, ocean, exin)
-		case "unsub":
-			if _, err := Redis(ctx).SRem(SubcribedUser, msgView.UserId).Result(); err != nil {
-				return err
-			}
-			return ant.client.SendPlainText(ctx, msgView, "Goodbye! But I am sure you will come back soon.")
-		case "help", "??":
-			return ant.client.SendPlainText(ctx, msgView, "Too young too simple. No help message.")
-		default:
-			reply, err := Reply(string(data))
-			if err != nil {
-				return ant.client.SendPlainText(ctx, msgView, "I am busy!!! Stop disturbing me.")
-			}
-			return ant.client.SendPlainText(ctx, msgView, reply)
+		req := &Request{
+			View:       msgView,
+			Text:       string(data),
+			Permission: ant.permission(msgView.UserId),
 		}
+		return ant.router.Dispatch(ctx, req)
 	}
 	return nil
 }
 
 func (ant *Ant) Notice(ctx context.Context, event ProfitEvent) error {
-	users, err := Redis(ctx).SMembers(SubcribedUser).Result()
+	subscribers, err := Storage(ctx).Subscribers()
 	if err != nil {
 		return err
 	}
-	actions := map[string]string{
-		PageSideBid: " Buy in Mixcoin",
-		PageSideAsk: "Sell in Mixcoin",
+	//取不到价格时不按数量过滤
+	value, priced := decimal.Zero, false
+	if price, err := ant.oracle.Price(ctx, event.Base, ReferenceAsset); err == nil {
+		value, priced = event.Amount.Mul(price), true
 	}
+	now := time.Now()
 
-	template := "Go Go Go!\nAction:  %-10s\nPair:         %-10s\nPrice:       %-10.8s\nAmount:    %-10s\nProfit:   %8s%%"
-	pair := Who(event.Base) + "/" + Who(event.Quote)
-	ocean := bot.Button{Label: "Mixcoin", Action: OceanWebsite, Color: "#2e8b57"}
-	exin := bot.Button{Label: "ExinOne", Action: fmt.Sprintf(ExinWebsite, PairIndex[pair]), Color: "#bc8f8f"}
-	msg := fmt.Sprintf(template, actions[event.Category], pair, event.Price.String(),
-		event.Amount.String(), event.Profit.Mul(decimal.NewFromFloat(100.0)).Round(2).String())
+	//模板有错误时退回纯文本
+	data := NewNoticeData(&event, now)
+	format := NoticeFormat
+	category, msg, err := RenderNotice(format, data)
+	if err != nil && format != NoticeText {
+		log.Println("render notice error", err)
+		format = NoticeText
+		category, msg, err = RenderNotice(format, data)
+	}
+	if err != nil {
+		return err
+	}
+	ocean := bot.Button{Label: "Mixcoin", Action: data.OceanURL, Color: "#2e8b57"}
+	exin := bot.Button{Label: "ExinOne", Action: data.ExinURL, Color: "#bc8f8f"}
 
-	for _, user := range users {
+	for _, subscriber := range subscribers {
+		if !subscriber.Wants(&event, value, priced, now) {
+			continue
+		}
 		msgView := bot.MessageView{
-			ConversationId: bot.UniqueConversationId(ClientId, user),
-			UserId:         user,
+			ConversationId: bot.UniqueConversationId(ClientId, subscriber.UserId),
+			UserId:         subscriber.UserId,
 		}
 
-		if err := ant.client.SendPlainText(ctx, msgView, msg); err != nil {
+		if err := SendMessage(ctx, msgView.ConversationId, msgView.UserId, category, msg); err != nil {
 			log.Println("Send message error", err)
 		}
-
+		//post里已经有两边的链接
+		if format == NoticePost {
+			continue
+		}
 		if err := ant.client.SendAppButtons(ctx, msgView.ConversationId, msgView.UserId, ocean, exin); err != nil {
 			log.Println("Trade error", err)
 		}
@@ -116,6 +94,13 @@ func (ant *Ant) Notice(ctx context.Context, event ProfitEvent) error {
 	return nil
 }
 
+func (ant *Ant) sendText(ctx context.Context, view bot.MessageView, text string) error {
+	if ant.send != nil {
+		return ant.send(ctx, view, text)
+	}
+	return ant.client.SendPlainText(ctx, view, text)
+}
+
 func (ant *Ant) PollMixinMessage(ctx context.Context) {
 	for {
 		ant.client = bot.NewBlazeClient(ClientId, SessionId, PrivateKey)
@@ -125,3 +110,17 @@ func (ant *Ant) PollMixinMessage(ctx context.Context) {
 		time.Sleep(1 * time.Second)
 	}
 }
+
+//给管理员发送告警
+func (ant *Ant) Alert(ctx context.Context, msg string) {
+	log.Println("ALERT", msg)
+	for _, user := range Admins {
+		msgView := bot.MessageView{
+			ConversationId: bot.UniqueConversationId(ClientId, user),
+			UserId:         user,
+		}
+		if err := ant.sendText(ctx, msgView, msg); err != nil {
+			log.Println("Send alert error", err)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+//管理员的user id，可以使用管理命令并接收告警
+var Admins = []string{}
+
+//管理员调整的开关保存在checkpoint中，重启后恢复
+const (
+	CheckpointPausedPairs = "admin-controls-paused-pairs"
+	CheckpointThreshold   = "admin-controls-threshold"
+)
+
+//管理命令的操作记录，没有权限的尝试也会记录
+type AuditLog struct {
+	ID        string    `json:"id"               gorm:"type:varchar(36);primary_key"`
+	UserId    string    `json:"user_id"          gorm:"type:varchar(36);index"`
+	Command   string    `json:"command"          gorm:"type:varchar(32)"`
+	Args      string    `json:"args"             gorm:"type:varchar(255)"`
+	Error     string    `json:"error"            gorm:"type:varchar(255)"`
+	CreatedAt time.Time `json:"created_at"       gorm:"index"`
+}
+
+func (AuditLog) TableName() string {
+	return "ant_audit_logs"
+}
+
+func (ant *Ant) audit(ctx context.Context, req *Request, err error) {
+	entry := AuditLog{
+		ID:        uuid.Must(uuid.NewV4()).String(),
+		UserId:    req.View.UserId,
+		Command:   req.Command,
+		Args:      strings.Join(req.Args, " "),
+		CreatedAt: time.Now(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	log.Printf("AUDIT %s %s %s %s", entry.UserId, entry.Command, entry.Args, entry.Error)
+	if err := Storage(ctx).SaveAuditLog(&entry); err != nil {
+		log.Println("create audit log error", err)
+	}
+}
+
+//管理员在运行中调整的开关：暂停的交易对和套利的收益阈值
+type controls struct {
+	sync.Mutex
+	paused    map[pausedPair]bool
+	threshold decimal.Decimal
+}
+
+type pausedPair struct {
+	base  string
+	quote string
+}
+
+func newControls() *controls {
+	return &controls{
+		paused:    make(map[pausedPair]bool, 0),
+		threshold: decimal.NewFromFloat(ProfitThreshold),
+	}
+}
+
+func (ant *Ant) pairPaused(base, quote string) bool {
+	ant.controls.Lock()
+	defer ant.controls.Unlock()
+	return ant.controls.paused[pausedPair{base, quote}]
+}
+
+//修改后写入checkpoint，写入失败时恢复原来的状态
+func (ant *Ant) setPairPaused(ctx context.Context, base, quote string, paused bool) error {
+	ant.controls.Lock()
+	defer ant.controls.Unlock()
+	pair := pausedPair{base, quote}
+	previous := ant.controls.paused[pair]
+	if paused {
+		ant.controls.paused[pair] = true
+	} else {
+		delete(ant.controls.paused, pair)
+	}
+	err := Storage(ctx).WriteCheckpoint(CheckpointPausedPairs, strings.Join(ant.controls.pairs(), ","))
+	if err != nil && previous {
+		ant.controls.paused[pair] = true
+	} else if err != nil {
+		delete(ant.controls.paused, pair)
+	}
+	return err
+}
+
+func (ant *Ant) pausedPairs() []string {
+	ant.controls.Lock()
+	defer ant.controls.Unlock()
+	return ant.controls.pairs()
+}
+
+//BTC/USDT形式，按字母排序
+func (c *controls) pairs() []string {
+	pairs := make([]string, 0, len(c.paused))
+	for pair := range c.paused {
+		pairs = append(pairs, Who(pair.base)+"/"+Who(pair.quote))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+//发现套利机会的最低收益率
+func (ant *Ant) threshold() decimal.Decimal {
+	ant.controls.Lock()
+	defer ant.controls.Unlock()
+	return ant.controls.threshold
+}
+
+func (ant *Ant) setThreshold(ctx context.Context, threshold decimal.Decimal) error {
+	ant.controls.Lock()
+	defer ant.controls.Unlock()
+	if err := Storage(ctx).WriteCheckpoint(CheckpointThreshold, threshold.String()); err != nil {
+		return err
+	}
+	ant.controls.threshold = threshold
+	return nil
+}
+
+//启动时恢复上次暂停的交易对和阈值
+func (ant *Ant) LoadControls(ctx context.Context) error {
+	pairs, err := Storage(ctx).ReadCheckpoint(CheckpointPausedPairs)
+	if err != nil {
+		return err
+	}
+	threshold, err := Storage(ctx).ReadCheckpoint(CheckpointThreshold)
+	if err != nil {
+		return err
+	}
+
+	ant.controls.Lock()
+	defer ant.controls.Unlock()
+	for _, pair := range strings.FieldsFunc(pairs, func(r rune) bool { return r == ',' }) {
+		base, quote, err := ParsePair(pair)
+		if err != nil {
+			return err
+		}
+		ant.controls.paused[pausedPair{base, quote}] = true
+	}
+	if threshold != "" {
+		value, err := decimal.NewFromString(threshold)
+		if err != nil {
+			return err
+		}
+		ant.controls.threshold = value
+	}
+	return nil
+}
+
+//撤掉所有未完成的ocean.one挂单，返回撤单的数量
+func (ant *Ant) cancelAll() int {
+	count := 0
+	for _, p := range ant.registry.List() {
+		ant.registry.Lock()
+		finished := p.done || p.cancelled
+		ant.registry.Unlock()
+		if !finished {
+			ant.cancel(p)
+			count += 1
+		}
+	}
+	return count
+}
+
+//运行状态：行情连接、盘口、队列和在途订单
+func (ant *Ant) Status() string {
+	now := time.Now()
+	lines := make([]string, 0)
+	for _, pair := range ant.watchedPairs() {
+		base, quote := pair[0], pair[1]
+		updatedAt, _, synced := ant.books[base+"-"+quote].Clock()
+		ocean := fmt.Sprintf("book %v old", now.Sub(updatedAt).Round(time.Second))
+		if !synced {
+			ocean = "book not synced"
+		}
+		exin := "no exin quote"
+		if depth := ant.quotes.get(base + "-" + quote); depth != nil {
+			exin = fmt.Sprintf("exin %v old", now.Sub(quotedAt(depth)).Round(time.Millisecond))
+		}
+		line := fmt.Sprintf("%s/%s: %s, %s", Who(base), Who(quote), ocean, exin)
+		if reason := ant.StaleReason(base, quote); reason != "" {
+			line += ", skipped: " + reason
+		}
+		lines = append(lines, line)
+	}
+	snapshots := "snapshots not synced"
+	if at := ant.balances.SyncedAt(); !at.IsZero() {
+		snapshots = fmt.Sprintf("snapshots synced %v ago", now.Sub(at).Round(time.Second))
+	}
+	lines = append(lines, snapshots)
+	lines = append(lines, fmt.Sprintf("ocean trading: %v, exin hedging: %v", ant.enableOcean, ant.enableExin))
+	lines = append(lines, fmt.Sprintf("queue: %d, in flight: %d", ant.queue.Len(), ant.registry.Len()))
+	lines = append(lines, fmt.Sprintf("threshold: %s%%", ant.threshold().Mul(decimal.NewFromFloat(100)).Round(4).String()))
+	if pairs := ant.pausedPairs(); len(pairs) > 0 {
+		lines = append(lines, "paused pairs: "+strings.Join(pairs, " "))
+	}
+	if assets := ant.balances.PausedAssets(); len(assets) > 0 {
+		symbols := make([]string, 0, len(assets))
+		for _, asset := range assets {
+			symbols = append(symbols, Who(asset))
+		}
+		lines = append(lines, "paused assets: "+strings.Join(symbols, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//管理命令，都需要PermissionAdmin并记入审计日志
+func (ant *Ant) registerAdminCommands() {
+	r := ant.router
+	r.Register(&Command{
+		Name:       "status",
+		Help:       "connections, books, queue and orders in flight",
+		Permission: PermissionAdmin,
+		MaxArgs:    0,
+		Handler: func(ctx context.Context, req *Request) error {
+			return ant.reply(ctx, req, ant.Status())
+		},
+	})
+	r.Register(&Command{
+		Name:       "balance",
+		Aliases:    []string{"assets"},
+		Help:       "show the bot's balances",
+		Permission: PermissionAdmin,
+		MaxArgs:    0,
+		Handler: func(ctx context.Context, req *Request) error {
+			ant.assetsLock.Lock()
+			lines := make([]string, 0, len(ant.assets))
+			for asset, balance := range ant.assets {
+				if !balance.IsPositive() {
+					continue
+				}
+				line := fmt.Sprintf("%-6s %s", Who(asset), balance.String())
+				if ant.balances.Paused(asset) {
+					line += " (paused)"
+				}
+				lines = append(lines, line)
+			}
+			ant.assetsLock.Unlock()
+			if len(lines) == 0 {
+				return ant.reply(ctx, req, "No balances yet.")
+			}
+			sort.Strings(lines)
+			return ant.reply(ctx, req, strings.Join(lines, "\n"))
+		},
+	})
+	r.Register(&Command{
+		Name:       "pause",
+		Usage:      "<pair>",
+		Help:       "stop opening new orders on a pair",
+		Permission: PermissionAdmin,
+		MinArgs:    1,
+		MaxArgs:    1,
+		Handler: func(ctx context.Context, req *Request) error {
+			base, quote, err := ParsePair(req.Args[0])
+			if err != nil {
+				return ant.router.Reject(ctx, req, err.Error())
+			}
+			if err := ant.setPairPaused(ctx, base, quote, true); err != nil {
+				return err
+			}
+			return ant.reply(ctx, req, fmt.Sprintf("%s/%s paused.", Who(base), Who(quote)))
+		},
+	})
+	r.Register(&Command{
+		Name:       "resume",
+		Usage:      "<pair|asset>",
+		Help:       "resume a paused pair, or an asset paused because its balance drifted",
+		Permission: PermissionAdmin,
+		MinArgs:    1,
+		MaxArgs:    1,
+		Handler: func(ctx context.Context, req *Request) error {
+			if asset := GetAssetId(strings.ToUpper(req.Args[0])); asset != "" {
+				if !ant.balances.Paused(asset) {
+					return ant.router.Reject(ctx, req, Who(asset)+" is not paused.")
+				}
+				ant.balances.Resume(asset)
+				return ant.reply(ctx, req, Who(asset)+" resumed, balances will be reconciled from the next reading.")
+			}
+			base, quote, err := ParsePair(req.Args[0])
+			if err != nil {
+				return ant.router.Reject(ctx, req, err.Error())
+			}
+			if !ant.pairPaused(base, quote) {
+				return ant.router.Reject(ctx, req, fmt.Sprintf("%s/%s is not paused.", Who(base), Who(quote)))
+			}
+			if err := ant.setPairPaused(ctx, base, quote, false); err != nil {
+				return err
+			}
+			return ant.reply(ctx, req, fmt.Sprintf("%s/%s resumed.", Who(base), Who(quote)))
+		},
+	})
+	r.Register(&Command{
+		Name:       "cancelall",
+		Help:       "cancel every open order on Ocean ONE, pause pairs first to keep strategies from placing new ones",
+		Permission: PermissionAdmin,
+		MaxArgs:    0,
+		Handler: func(ctx context.Context, req *Request) error {
+			count := ant.cancelAll()
+			return ant.reply(ctx, req, fmt.Sprintf("%d orders cancelled.", count))
+		},
+	})
+	r.Register(&Command{
+		Name:       "pnl",
+		Usage:      "[today|yesterday]",
+		Help:       "realized profit by strategy",
+		Permission: PermissionAdmin,
+		MaxArgs:    1,
+		Handler: func(ctx context.Context, req *Request) error {
+			from := time.Now().UTC().Truncate(24 * time.Hour)
+			if len(req.Args) == 1 {
+				switch strings.ToLower(req.Args[0]) {
+				case "today":
+				case "yesterday":
+					from = from.Add(-24 * time.Hour)
+				default:
+					return ant.router.Reject(ctx, req, "Usage: pnl [today|yesterday]")
+				}
+			}
+			summaries, err := QueryPnL(ctx, from, from.Add(24*time.Hour), "strategy")
+			if err != nil {
+				ant.reply(ctx, req, err.Error())
+				return err
+			}
+			lines := []string{from.Format("2006-01-02") + " (" + Who(ReferenceAsset) + ")"}
+			total, fee, events := decimal.Zero, decimal.Zero, 0
+			for _, s := range summaries {
+				lines = append(lines, fmt.Sprintf("%-10s %4d  %s  fee %s", s.Strategy, s.Events, s.Reference.Round(4).String(), s.Fee.Round(4).String()))
+				total, fee, events = total.Add(s.Reference), fee.Add(s.Fee), events+s.Events
+			}
+			lines = append(lines, fmt.Sprintf("%-10s %4d  %s  fee %s", "total", events, total.Round(4).String(), fee.Round(4).String()))
+			return ant.reply(ctx, req, strings.Join(lines, "\n"))
+		},
+	})
+	r.Register(&Command{
+		Name:       "threshold",
+		Usage:      "[percent]",
+		Help:       "show or change the minimum profit of an opportunity",
+		Permission: PermissionAdmin,
+		MaxArgs:    1,
+		Handler: func(ctx context.Context, req *Request) error {
+			percent := decimal.NewFromFloat(100)
+			previous := ant.threshold()
+			if len(req.Args) == 0 {
+				return ant.reply(ctx, req, fmt.Sprintf("Threshold %s%%.", previous.Mul(percent).Round(4).String()))
+			}
+			value, err := decimal.NewFromString(strings.TrimSuffix(req.Args[0], "%"))
+			if err != nil || !value.IsPositive() {
+				return ant.router.Reject(ctx, req, "threshold needs a positive percentage")
+			}
+			if err := ant.setThreshold(ctx, value.Div(percent)); err != nil {
+				return err
+			}
+			return ant.reply(ctx, req, fmt.Sprintf("Threshold %s%% -> %s%%.", previous.Mul(percent).Round(4).String(), value.String()))
+		},
+	})
+	r.Audit(ant.audit)
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/MixinNetwork/bot-api-go-client"
-	"github.com/emirpasic/gods/lists/arraylist"
 	uuid "github.com/satori/go.uuid"
 	"github.com/shopspring/decimal"
 )
@@ -20,11 +19,18 @@ const (
 	OceanFee        = 0.001
 	ExinFee         = 0.003
 	OrderExpireTime = int64(5 * time.Second)
+
+	StrategyArbitrage = "arbitrage"
+	StrategyFishing   = "fishing"
+	StrategyTriangle  = "triangle"
+	StrategyMaker     = "maker"
+	StrategyFallback  = "fallback"
 )
 
 type ProfitEvent struct {
 	ID            string          `json:"-"                gorm:"type:varchar(36);primary_key"`
 	Category      string          `json:"category"         gorm:"type:varchar(10)"`
+	Strategy      string          `json:"strategy"         gorm:"type:varchar(16)"`
 	Price         decimal.Decimal `json:"price"            gorm:"type:varchar(36)"`
 	Profit        decimal.Decimal `json:"profit"           gorm:"type:varchar(36)"`
 	Amount        decimal.Decimal `json:"amount"           gorm:"type:varchar(36)"`
@@ -48,32 +54,59 @@ type Ant struct {
 	//是否开启交易
 	enableOcean bool
 	enableExin  bool
-	//发现套利机会
-	event chan *ProfitEvent
-	//所有交易的snapshot_id
-	snapshots map[string]bool
-	//机器人向ocean.one交易的trace_id
-	orders map[string]bool
+	//发现的套利机会，按预期收益排序
+	queue *OpportunityQueue
+	//行情数据过期时跳过的原因
+	stale staleReasons
+	//两边的价差历史
+	spreads *SpreadRecorder
+	//最新的exin报价
+	quotes *exinQuotes
+	//管理员暂停的交易对和调整的阈值
+	controls *controls
+	//已处理的snapshot_id
+	snapshots *snapshotDedupe
 	//买单和卖单的红黑树，生成深度用
-	books      map[string]*OrderBook
-	OrderQueue *arraylist.List
-	assetsLock sync.Mutex
-	assets     map[string]decimal.Decimal
-	client     *bot.BlazeClient
+	books map[string]*OrderBook
+	//资产价格
+	oracle *PriceOracle
+	//ocean.one上的在途订单，撤单退款和对冲完成前一直保留
+	registry *Registry
+	//不足exin最小交易量的对冲余数
+	residualsLock sync.Mutex
+	residuals     map[string]*residual
+	assetsLock    sync.Mutex
+	assets        map[string]decimal.Decimal
+	//用snapshot核对余额
+	balances *BalanceReconciler
+	client   *bot.BlazeClient
+	//发送文本消息，为nil时用client发送，测试中替换
+	send func(ctx context.Context, view bot.MessageView, text string) error
+	//聊天命令
+	router *Router
 }
 
 func NewAnt(ocean, exin bool) *Ant {
-	return &Ant{
+	ant := &Ant{
 		enableOcean: ocean,
 		enableExin:  exin,
-		event:       make(chan *ProfitEvent, 10),
-		snapshots:   make(map[string]bool, 0),
-		orders:      make(map[string]bool, 0),
+		snapshots:   newSnapshotDedupe(),
 		books:       make(map[string]*OrderBook, 0),
+		registry:    NewRegistry(),
+		residuals:   make(map[string]*residual, 0),
 		assets:      make(map[string]decimal.Decimal, 0),
-		OrderQueue:  arraylist.New(),
+		balances:    NewBalanceReconciler(),
+		spreads:     NewSpreadRecorder(),
+		quotes:      &exinQuotes{depths: make(map[string]*Depth, 0)},
+		controls:    newControls(),
 		client:      bot.NewBlazeClient(ClientId, SessionId, PrivateKey),
 	}
+	ant.queue = NewOpportunityQueue(OpportunityTTL, ant.busy)
+	ant.oracle = NewPriceOracle(ant.books)
+	ant.router = NewRouter(ant.reply)
+	ant.registerCommands()
+	ant.stale.reasons = make(map[string]string, 0)
+	return ant
 }
 
 func UuidWithString(str string) string {
@@ -91,89 +124,63 @@ func (ant *Ant) OnOrderMessage(base, quote string) *OrderBook {
 	return ant.books[pair]
 }
 
-func (ant *Ant) Clean() {
-	for trace, ok := range ant.orders {
-		if !ok {
-			OceanCancel(trace)
-		}
-	}
-	//TODO, event中baseAmount和quoteAmout的数量和预期不一致
-	log.Println("+++exit because ctrl-c++++")
-}
-
 func (ant *Ant) trade(ctx context.Context, e *ProfitEvent) error {
-	exchangeOrder := UuidWithString(e.ID + OceanCore)
-	if _, ok := ant.orders[exchangeOrder]; ok {
+	if ant.registry.Seen(e.ID) {
 		return nil
 	}
-
 	defer func() {
-		time.AfterFunc(time.Duration(OrderExpireTime), func() {
-			if err := OceanCancel(exchangeOrder); err == nil {
-				ant.orders[exchangeOrder] = true
-			}
-		})
-
 		go ant.Notice(ctx, *e)
 	}()
 
 	if !ant.enableOcean {
-		ant.orders[exchangeOrder] = true
+		ant.registry.Finish(e.ID)
 		return nil
 	}
-
-	amount := e.Amount
-	//多付款，保证扣完手续费后能全买下
-	if e.Category == PageSideBid {
-		amount = amount.Mul(decimal.NewFromFloat(1.1))
+	if !ant.tradable(e.Base, e.Quote) || ant.pairPaused(e.Base, e.Quote) {
+		return nil
 	}
 
 	ant.assetsLock.Lock()
-	baseBalance := ant.assets[e.Base]
-	quoteBalance := ant.assets[e.Quote]
-	ant.assetsLock.Unlock()
-	if amount.GreaterThan(baseBalance) {
-		amount = baseBalance
-	}
+	balance := ant.assets[e.Base]
 	if e.Category == PageSideBid {
-		amount = e.Amount.Mul(e.Price)
-		if amount.GreaterThan(quoteBalance) {
-			amount = quoteBalance
-		}
+		balance = ant.assets[e.Quote]
 	}
-
-	ant.orders[exchangeOrder] = false
-	_, err := OceanTrade(e.Category, e.Price.String(), amount.String(), OrderTypeLimit, e.Base, e.Quote, exchangeOrder)
+	ant.assetsLock.Unlock()
+	size, err := SizeOrder(e.Category, e.Price, e.Amount, balance)
 	if err != nil {
 		return err
 	}
+	e.Price = size.Price
 
-	e.ExchangeOrder = exchangeOrder
-	ant.OrderQueue.Add(e)
-
-	if err := Database(ctx).FirstOrCreate(e).Error; err != nil {
+	e.ExchangeOrder = UuidWithString(e.ID + OceanCore)
+	p := ant.track(e, true, false)
+	if p == nil {
+		return nil
+	}
+	policy := PolicyOf(e.Strategy)
+	if policy.Type == OrderTypeLimit {
+		ant.registry.Lock()
+		p.resting = true
+		ant.registry.Unlock()
+	}
+	if _, err := oceanTrade(e.Category, policy.Price(size), size.Send.String(), policy.Type, e.Base, e.Quote, e.ExchangeOrder); err != nil {
+		ant.untrack(p)
 		return err
 	}
-	return nil
-}
-
-func LimitAmount(amount, balance, min, max decimal.Decimal) decimal.Decimal {
-	if amount.LessThanOrEqual(min) {
-		log.Printf("amount too small, %v < min : %v", amount, min)
-		return decimal.Zero
+	//市价单未成交的部分会直接退回，limit单到期撤单
+	if policy.Type == OrderTypeLimit {
+		time.AfterFunc(policy.Lifetime, func() {
+			ant.cancel(p)
+		})
 	}
 
-	less := max
-	if max.GreaterThan(balance) {
-		less = balance
-	}
-	if amount.GreaterThan(less) {
-		return less
+	if err := Storage(ctx).SaveEvent(e); err != nil {
+		return err
 	}
-	return amount
+	return nil
 }
 
-//订单有效期过后3s去exin上进行交易
+//重试未完成的对冲，订单撤销后做最后的对账
 func (ant *Ant) OnExpire(ctx context.Context) error {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -182,107 +189,26 @@ func (ant *Ant) OnExpire(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			removed := make([]*ProfitEvent, 0)
-			for it := ant.OrderQueue.Iterator(); it.Next(); {
-				event := it.Value().(*ProfitEvent)
-				//获利了结或者未成交全部取消的订单
-				if !event.BaseAmount.Mul(event.Price).Add(event.QuoteAmount).IsNegative() {
-					removed = append(removed, event)
-				}
-				//受exin限制无法成交的订单
-				if event.CreatedAt.Add(time.Duration(event.Expire)).Add(1 * time.Minute).Before(time.Now()) {
-					removed = append(removed, event)
-				}
-				//每笔订单最后都会取消，这里留3s收退款
-				if event.CreatedAt.Add(time.Duration(event.Expire)).Add(3 * time.Second).Before(time.Now()) {
-					amount := event.BaseAmount
-					send, side := event.Base, PageSideAsk
-					if !amount.IsPositive() {
-						amount = event.QuoteAmount
-						send, side = event.Quote, PageSideBid
-						if !amount.IsPositive() {
-							continue
-						}
-					}
-
-					ant.assetsLock.Lock()
-					balance := ant.assets[send]
-					ant.assetsLock.Unlock()
-					limited := LimitAmount(amount, balance, event.Min, event.Max)
-					if send == event.Quote {
-						limited = LimitAmount(amount, balance, event.Min.Mul(event.Price), event.Max.Mul(event.Price))
-					}
-
-					if !limited.IsPositive() {
-						log.Printf("%s, balance: %v, min: %v, send: %v,amount: %v, limited: %v", Who(send), balance, event.Min, send, amount, limited)
-					} else {
-						otcOrder := UuidWithString(event.ID + ExinCore)
-						if _, err := ExinTrade(side, limited.String(), event.Base, event.Quote, otcOrder); err != nil {
-							log.Println(err)
-							continue
-						}
-						event.OtcOrder = otcOrder
-					}
-					ant.orders[event.ExchangeOrder] = true
-				}
-			}
-			if len(removed) > 0 {
-				for _, event := range removed {
-					index := ant.OrderQueue.IndexOf(event)
-					ant.OrderQueue.Remove(index)
-					updates := map[string]interface{}{"base_amount": event.BaseAmount, "quote_amount": event.QuoteAmount, "otc_order": event.OtcOrder}
-					if err := Database(ctx).Model(event).Where("id=?", event.ID).Updates(updates).Error; err != nil {
-						log.Println("update event error", err)
-					}
-				}
+			for _, p := range ant.registry.List() {
+				ant.hedge(ctx, p)
+				ant.fallback(ctx, p)
+				ant.reconcile(ctx, p, false)
 			}
+			ant.flushResiduals(ctx)
 		}
 	}
 }
 
-func (ant *Ant) HandleSnapshot(ctx context.Context, s *Snapshot) error {
-	amount, _ := decimal.NewFromString(s.Amount)
-	matched := &ProfitEvent{}
-	for it := ant.OrderQueue.Iterator(); it.Next(); {
-		event := it.Value().(*ProfitEvent)
-		if s.OpponentId == ExinCore {
-			var reply ExinReply
-			if err := reply.Unpack(s.Data); err != nil {
-				return err
-			}
-			if event.OtcOrder == s.TraceId || event.OtcOrder == reply.O.String() {
-				matched = event
-				it.End()
-			}
-		} else {
-			var reply OceanReply
-			if err := reply.Unpack(s.Data); err != nil {
-				return err
-			}
-			if event.ExchangeOrder == s.TraceId || event.ExchangeOrder == reply.A.String() || event.ExchangeOrder == reply.B.String() || event.ExchangeOrder == reply.O.String() {
-				matched = event
-				it.End()
-			}
-		}
-	}
-
-	if s.AssetId == matched.Base {
-		matched.BaseAmount = matched.BaseAmount.Add(amount)
-	} else if s.AssetId == matched.Quote {
-		matched.QuoteAmount = matched.QuoteAmount.Add(amount)
-	}
-	return nil
-}
-
 func (ant *Ant) Trade(ctx context.Context) error {
-	if ant.enableExin {
-		go ant.OnExpire(ctx)
-	}
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case e := <-ant.event:
+		default:
+			e, err := ant.queue.Pop(ctx)
+			if err != nil {
+				continue
+			}
 			if err := ant.trade(ctx, e); err != nil {
 				log.Println(err)
 			}
@@ -299,13 +225,15 @@ func (ant *Ant) Watching(ctx context.Context, base, quote string) {
 		default:
 			if otc, err := GetExinDepth(ctx, base, quote); err == nil {
 				pair := base + "-" + quote
+				ant.quotes.set(pair, otc)
 				if exchange := ant.books[pair].GetDepth(3); exchange != nil {
+					ant.spreads.Observe(ctx, base, quote, exchange, otc)
 					if len(exchange.Bids) > 0 && len(otc.Asks) > 0 {
-						ant.Inspect(ctx, exchange.Bids[0], otc.Asks[0], base, quote, PageSideBid, OrderExpireTime)
+						ant.Inspect(ctx, exchange.Bids[0], otc.Asks[0], base, quote, PageSideBid, StrategyArbitrage)
 					}
 
 					if len(exchange.Asks) > 0 && len(otc.Bids) > 0 {
-						ant.Inspect(ctx, exchange.Asks[0], otc.Bids[0], base, quote, PageSideAsk, OrderExpireTime)
+						ant.Inspect(ctx, exchange.Asks[0], otc.Bids[0], base, quote, PageSideAsk, StrategyArbitrage)
 					}
 				}
 			}
@@ -315,7 +243,13 @@ func (ant *Ant) Watching(ctx context.Context, base, quote string) {
 }
 
 //判断有无获利机会
-func (ant *Ant) Inspect(ctx context.Context, exchange, otc Order, base, quote string, side string, expire int64) {
+func (ant *Ant) Inspect(ctx context.Context, exchange, otc Order, base, quote string, side, strategy string) {
+	if err := ant.checkFresh(base, quote, otc); err != nil {
+		ant.stale.report(base+"-"+quote, err.Error())
+		return
+	}
+	ant.stale.report(base+"-"+quote, "")
+
 	var category string
 	if side == PageSideBid {
 		category = PageSideAsk
@@ -328,7 +262,7 @@ func (ant *Ant) Inspect(ctx context.Context, exchange, otc Order, base, quote st
 		profit = profit.Mul(decimal.NewFromFloat(-1.0))
 	}
 
-	if profit.LessThan(decimal.NewFromFloat(ProfitThreshold)) {
+	if profit.LessThan(ant.threshold()) {
 		return
 	}
 
@@ -340,6 +274,7 @@ func (ant *Ant) Inspect(ctx context.Context, exchange, otc Order, base, quote st
 	event := ProfitEvent{
 		ID:          id,
 		Category:    category,
+		Strategy:    strategy,
 		Price:       exchange.Price,
 		Amount:      amount,
 		Min:         otc.Min,
@@ -347,16 +282,12 @@ func (ant *Ant) Inspect(ctx context.Context, exchange, otc Order, base, quote st
 		Profit:      profit,
 		Base:        base,
 		Quote:       quote,
-		Expire:      expire,
+		Expire:      int64(PolicyOf(strategy).Lifetime),
 		CreatedAt:   time.Now(),
 		BaseAmount:  decimal.Zero,
 		QuoteAmount: decimal.Zero,
 	}
-	select {
-	case ant.event <- &event:
-	case <-time.After(5 * time.Second):
-	}
-	return
+	ant.queue.Push(&event, ant.expectedProfit(&event))
 }
 
 func (ant *Ant) UpdateBalance(ctx context.Context) error {
@@ -364,18 +295,26 @@ func (ant *Ant) UpdateBalance(ctx context.Context) error {
 	defer ticker.Stop()
 
 	update := func() {
+		readAt := time.Now()
 		assets, err := ReadAssets(ctx)
 		if err != nil {
 			return
 		}
+		balances := make(map[string]decimal.Decimal, len(assets))
 		for asset, balance := range assets {
 			b, err := decimal.NewFromString(balance)
-			if err == nil && !b.Equal(ant.assets[asset]) {
+			if err != nil {
+				continue
+			}
+			balances[asset] = b
+			if !b.Equal(ant.assets[asset]) {
 				ant.assetsLock.Lock()
 				ant.assets[asset] = b
 				ant.assetsLock.Unlock()
 			}
 		}
+		ant.balances.Observe(readAt, balances)
+		ant.checkBalances(ctx)
 	}
 
 	update()
@@ -0,0 +1,239 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//余额和snapshot推算值允许的误差
+	BalanceTolerance = 0.00000001
+	//snapshot轮询追上余额读取时间后再等一会，容忍两边时钟的误差
+	BalanceSyncDelay = 10 * time.Second
+	//连续多少次对不上就暂停该资产的交易
+	BalanceDriftLimit = 3
+)
+
+//ocean.one和exin之外的余额变化也会被计入，只要是机器人自己的snapshot
+type balanceReading struct {
+	at       time.Time
+	balances map[string]decimal.Decimal
+}
+
+type balanceDelta struct {
+	at     time.Time
+	asset  string
+	amount decimal.Decimal
+}
+
+//用第一次读到的余额加上之后所有snapshot推算每种资产的余额，和实际余额比较；
+//比较的是累计差额，snapshot落在哪一次读取前后的时间误差会在下一次自动抵消
+type BalanceReconciler struct {
+	sync.Mutex
+	//开始推算的时间，anchor是上一次读数时推算出的余额
+	startedAt time.Time
+	anchor    *balanceReading
+	pending   []*balanceReading
+	deltas    []balanceDelta
+	syncedAt  time.Time
+	//每种资产上一次的累计差额和连续对不上的次数
+	diffs  map[string]decimal.Decimal
+	drifts map[string]int
+	paused map[string]bool
+}
+
+func NewBalanceReconciler() *BalanceReconciler {
+	return &BalanceReconciler{
+		diffs:  make(map[string]decimal.Decimal, 0),
+		drifts: make(map[string]int, 0),
+		paused: make(map[string]bool, 0),
+	}
+}
+
+//记录一次ReadAssets的结果
+func (r *BalanceReconciler) Observe(at time.Time, balances map[string]decimal.Decimal) {
+	r.Lock()
+	defer r.Unlock()
+	reading := &balanceReading{at: at, balances: balances}
+	if r.anchor == nil {
+		r.startedAt = at
+		r.anchor = reading
+		return
+	}
+	r.pending = append(r.pending, reading)
+}
+
+//记录一笔已处理的snapshot
+func (r *BalanceReconciler) Apply(s *Snapshot) {
+	if s.UserId != ClientId {
+		return
+	}
+	amount, err := decimal.NewFromString(s.Amount)
+	if err != nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.anchor == nil || !s.CreatedAt.After(r.startedAt) {
+		return
+	}
+	r.deltas = append(r.deltas, balanceDelta{at: s.CreatedAt, asset: s.AssetId, amount: amount})
+}
+
+//at之前的snapshot都已经处理过
+func (r *BalanceReconciler) Synced(at time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	if at.After(r.syncedAt) {
+		r.syncedAt = at
+	}
+}
+
+func (r *BalanceReconciler) Paused(asset string) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.paused[asset]
+}
+
+func (r *BalanceReconciler) PausedAssets() []string {
+	r.Lock()
+	defer r.Unlock()
+	assets := make([]string, 0, len(r.paused))
+	for asset := range r.paused {
+		assets = append(assets, asset)
+	}
+	return assets
+}
+
+//snapshot轮询追上的时间
+func (r *BalanceReconciler) SyncedAt() time.Time {
+	r.Lock()
+	defer r.Unlock()
+	return r.syncedAt
+}
+
+//恢复资产的交易，以下一次读到的余额重新开始推算
+func (r *BalanceReconciler) Resume(asset string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.paused, asset)
+	delete(r.drifts, asset)
+	delete(r.diffs, asset)
+	r.anchor, r.pending, r.deltas = nil, nil, nil
+}
+
+//余额和推算值对不上的记录
+type BalanceDiscrepancy struct {
+	ID        string          `json:"id"               gorm:"type:varchar(36);primary_key"`
+	AssetId   string          `json:"asset_id"         gorm:"type:varchar(36);index"`
+	Expected  decimal.Decimal `json:"expected"         gorm:"type:varchar(36)"`
+	Actual    decimal.Decimal `json:"actual"           gorm:"type:varchar(36)"`
+	Diff      decimal.Decimal `json:"diff"             gorm:"type:varchar(36)"`
+	Drifts    int             `json:"drifts"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (BalanceDiscrepancy) TableName() string {
+	return "ant_balance_discrepancies"
+}
+
+//比较snapshot已经追上的读数，返回累计差额变化的资产，和这次需要暂停的资产
+func (r *BalanceReconciler) check() ([]BalanceDiscrepancy, []string) {
+	r.Lock()
+	defer r.Unlock()
+	discrepancies := make([]BalanceDiscrepancy, 0)
+	paused := make([]string, 0)
+	if r.anchor == nil {
+		return discrepancies, paused
+	}
+	tolerance := decimal.NewFromFloat(BalanceTolerance)
+	for len(r.pending) > 0 && !r.pending[0].at.Add(BalanceSyncDelay).After(r.syncedAt) {
+		reading := r.pending[0]
+		r.pending = r.pending[1:]
+
+		expected := make(map[string]decimal.Decimal, len(r.anchor.balances))
+		for asset, balance := range r.anchor.balances {
+			expected[asset] = balance
+		}
+		left := make([]balanceDelta, 0)
+		for _, delta := range r.deltas {
+			if delta.at.After(reading.at) {
+				left = append(left, delta)
+				continue
+			}
+			expected[delta.asset] = expected[delta.asset].Add(delta.amount)
+		}
+		//已计入的snapshot合并到anchor里
+		r.anchor = &balanceReading{at: reading.at, balances: expected}
+		r.deltas = left
+		assets := make(map[string]bool, 0)
+		for asset := range expected {
+			assets[asset] = true
+		}
+		for asset := range reading.balances {
+			assets[asset] = true
+		}
+
+		for asset := range assets {
+			actual := reading.balances[asset]
+			diff := actual.Sub(expected[asset])
+			if diff.Abs().LessThanOrEqual(tolerance) {
+				diff = decimal.Zero
+				r.drifts[asset] = 0
+			} else {
+				r.drifts[asset] += 1
+			}
+			if !diff.Equal(r.diffs[asset]) {
+				discrepancies = append(discrepancies, BalanceDiscrepancy{
+					ID:        uuid.Must(uuid.NewV4()).String(),
+					AssetId:   asset,
+					Expected:  expected[asset],
+					Actual:    actual,
+					Diff:      diff,
+					Drifts:    r.drifts[asset],
+					CreatedAt: reading.at,
+				})
+				r.diffs[asset] = diff
+			}
+			if r.drifts[asset] >= BalanceDriftLimit && !r.paused[asset] {
+				r.paused[asset] = true
+				paused = append(paused, asset)
+			}
+		}
+	}
+	return discrepancies, paused
+}
+
+//记录余额差异，持续对不上的资产告警并暂停交易
+func (ant *Ant) checkBalances(ctx context.Context) {
+	discrepancies, paused := ant.balances.check()
+	for _, d := range discrepancies {
+		if d.Diff.IsZero() {
+			log.Printf("balance of %s reconciled, %v", Who(d.AssetId), d.Actual)
+		} else {
+			log.Printf("balance of %s diverges, expected %v, actual %v", Who(d.AssetId), d.Expected, d.Actual)
+		}
+		if err := Storage(ctx).SaveDiscrepancy(&d); err != nil {
+			log.Println("create discrepancy error", err)
+		}
+	}
+	for _, asset := range paused {
+		ant.Alert(ctx, fmt.Sprintf("balance of %s drifts for %d checks, trading on it is paused", Who(asset), BalanceDriftLimit))
+	}
+}
+
+//涉及的资产都没有因为余额对不上而暂停
+func (ant *Ant) tradable(assets ...string) bool {
+	for _, asset := range assets {
+		if ant.balances.Paused(asset) {
+			return false
+		}
+	}
+	return true
+}
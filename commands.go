@@ -0,0 +1,209 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	bot "github.com/MixinNetwork/bot-api-go-client"
+)
+
+func (ant *Ant) reply(ctx context.Context, req *Request, text string) error {
+	return ant.sendText(ctx, req.View, text)
+}
+
+func (ant *Ant) marketOf(ctx context.Context, pair string, levels int) (*MarketView, error) {
+	base, quote, err := ParsePair(pair)
+	if err != nil {
+		return nil, err
+	}
+	return ant.Market(ctx, base, quote, levels, true)
+}
+
+//用户的权限等级
+func (ant *Ant) permission(userId string) int {
+	for _, admin := range Admins {
+		if admin == userId {
+			return PermissionAdmin
+		}
+	}
+	return PermissionUser
+}
+
+//聊天机器人的命令，新命令在这里注册
+func (ant *Ant) registerCommands() {
+	r := ant.router
+	r.Register(&Command{
+		Name:    "help",
+		Aliases: []string{"??"},
+		Usage:   "[command]",
+		Help:    "show commands",
+		MaxArgs: 1,
+		Handler: func(ctx context.Context, req *Request) error {
+			if len(req.Args) == 1 {
+				if help, ok := r.HelpOf(req.Args[0], req.Permission); ok {
+					return ant.reply(ctx, req, help)
+				}
+				return ant.reply(ctx, req, "No such command: "+req.Args[0])
+			}
+			return ant.reply(ctx, req, r.Help(req.Permission))
+		},
+	})
+	r.Register(&Command{
+		Name:    "sub",
+		Help:    "get notified of arbitrage opportunities",
+		MaxArgs: 0,
+		Handler: func(ctx context.Context, req *Request) error {
+			if err := Storage(ctx).Subscribe(req.View.UserId); err != nil {
+				log.Println("Add user err", err)
+			}
+			ant.reply(ctx, req, "Thanks for your attention.\n You may get a notification if you can benefit from the price differences below.")
+			ocean := bot.Button{Label: "Mixcoin", Action: OceanWebsite, Color: "#2e8b57"}
+			exin := bot.Button{Label: "ExinOne", Action: fmt.Sprintf(ExinWebsite, 15), Color: "#bc8f8f"}
+			return ant.client.SendAppButtons(ctx, req.View.ConversationId, req.View.UserId, ocean, exin)
+		},
+	})
+	r.Register(&Command{
+		Name:    "unsub",
+		Help:    "stop notifications",
+		MaxArgs: 0,
+		Handler: func(ctx context.Context, req *Request) error {
+			if err := Storage(ctx).Unsubscribe(req.View.UserId); err != nil {
+				return err
+			}
+			return ant.reply(ctx, req, "Goodbye! But I am sure you will come back soon.")
+		},
+	})
+	r.Register(&Command{
+		Name:    "prefs",
+		Help:    "show your notification preferences",
+		MaxArgs: 0,
+		Handler: func(ctx context.Context, req *Request) error {
+			subscriber, err := Storage(ctx).FindSubscriber(req.View.UserId)
+			if err != nil {
+				return err
+			}
+			if subscriber == nil {
+				return ant.reply(ctx, req, "You are not subscribed, send sub first.")
+			}
+			return ant.reply(ctx, req, subscriber.String())
+		},
+	})
+	r.Register(&Command{
+		Name:    "set",
+		Usage:   "<option> <value...>",
+		Help:    "change a preference: pairs BTC/USDT ETH/BTC|all, side buy|sell|all, profit <percent>, amount <" + Who(ReferenceAsset) + ">, quiet 23:00-07:00 [Asia/Shanghai]|off",
+		MinArgs: 2,
+		MaxArgs: -1,
+		Handler: func(ctx context.Context, req *Request) error {
+			subscriber, err := Storage(ctx).FindSubscriber(req.View.UserId)
+			if err != nil {
+				return err
+			}
+			if subscriber == nil {
+				return ant.reply(ctx, req, "You are not subscribed, send sub first.")
+			}
+			if err := subscriber.Set(req.Args[0], req.Args[1:]); err != nil {
+				return ant.reply(ctx, req, err.Error())
+			}
+			if err := Storage(ctx).SaveSubscriber(subscriber); err != nil {
+				return err
+			}
+			return ant.reply(ctx, req, subscriber.String())
+		},
+	})
+	r.Register(&Command{
+		Name:    "reset",
+		Help:    "clear your preferences and get every notification",
+		MaxArgs: 0,
+		Handler: func(ctx context.Context, req *Request) error {
+			subscriber, err := Storage(ctx).FindSubscriber(req.View.UserId)
+			if err != nil {
+				return err
+			}
+			if subscriber == nil {
+				return ant.reply(ctx, req, "You are not subscribed, send sub first.")
+			}
+			fresh := NewSubscriber(subscriber.UserId)
+			fresh.CreatedAt = subscriber.CreatedAt
+			if err := Storage(ctx).SaveSubscriber(fresh); err != nil {
+				return err
+			}
+			return ant.reply(ctx, req, fresh.String())
+		},
+	})
+	r.Register(&Command{
+		Name:    "price",
+		Usage:   "<pair>",
+		Help:    "best bid and ask on Mixcoin and ExinOne, e.g. price BTC/USDT",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Handler: func(ctx context.Context, req *Request) error {
+			m, err := ant.marketOf(ctx, req.Args[0], 1)
+			if err != nil {
+				return ant.reply(ctx, req, err.Error())
+			}
+			return ant.reply(ctx, req, m.PriceText())
+		},
+	})
+	r.Register(&Command{
+		Name:    "depth",
+		Usage:   "<pair> [levels]",
+		Help:    "order book levels, e.g. depth ETH/BTC 5",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Handler: func(ctx context.Context, req *Request) error {
+			levels := 5
+			if len(req.Args) == 2 {
+				n, err := strconv.Atoi(req.Args[1])
+				if err != nil || n < 1 || n > MarketMaxDepth {
+					return ant.reply(ctx, req, fmt.Sprintf("levels must be between 1 and %d", MarketMaxDepth))
+				}
+				levels = n
+			}
+			m, err := ant.marketOf(ctx, req.Args[0], levels)
+			if err != nil {
+				return ant.reply(ctx, req, err.Error())
+			}
+			return ant.reply(ctx, req, m.DepthText())
+		},
+	})
+	r.Register(&Command{
+		Name:    "spread",
+		Usage:   "[pair]",
+		Help:    "current spreads against the profit threshold, all pairs by default",
+		MaxArgs: 1,
+		Handler: func(ctx context.Context, req *Request) error {
+			if len(req.Args) == 1 {
+				m, err := ant.marketOf(ctx, req.Args[0], 1)
+				if err != nil {
+					return ant.reply(ctx, req, err.Error())
+				}
+				return ant.reply(ctx, req, m.SpreadText())
+			}
+			//所有交易对只用缓存的报价
+			texts := make([]string, 0)
+			for _, pair := range ant.watchedPairs() {
+				m, err := ant.Market(ctx, pair[0], pair[1], 1, false)
+				if err != nil {
+					continue
+				}
+				texts = append(texts, m.SpreadText())
+			}
+			if len(texts) == 0 {
+				return ant.reply(ctx, req, "No markets are watched.")
+			}
+			return ant.reply(ctx, req, strings.Join(texts, "\n\n"))
+		},
+	})
+	ant.registerAdminCommands()
+	r.Fallback(func(ctx context.Context, req *Request) error {
+		reply, err := Reply(req.Text)
+		if err != nil {
+			return ant.reply(ctx, req, "I am busy!!! Stop disturbing me.")
+		}
+		return ant.reply(ctx, req, reply)
+	})
+}
@@ -3,28 +3,19 @@ package ant
 import (
 	"context"
 
-	"github.com/go-redis/redis"
 	"github.com/jinzhu/gorm"
 )
 
 const (
 	DatabaseContextKey = "database_context_key"
-	keyRedis           = "redis_context_key"
 )
 
+//内存存储时没有数据库，返回nil
 func Database(ctx context.Context) *gorm.DB {
-	return ctx.Value(DatabaseContextKey).(*gorm.DB)
+	v, _ := ctx.Value(DatabaseContextKey).(*gorm.DB)
+	return v
 }
 
 func SetDB(ctx context.Context, db *gorm.DB) context.Context {
 	return context.WithValue(ctx, DatabaseContextKey, db)
 }
-
-func SetupRedis(ctx context.Context, client *redis.Client) context.Context {
-	return context.WithValue(ctx, keyRedis, client)
-}
-
-func Redis(ctx context.Context) *redis.Client {
-	v, _ := ctx.Value(keyRedis).(*redis.Client)
-	return v
-}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -11,14 +12,55 @@ import (
 
 	"github.com/MooooonStar/ant"
 	"github.com/go-redis/redis"
-	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/shopspring/decimal"
 	"github.com/urfave/cli"
 )
 
 var baseSymbols = []string{"BTC", "EOS", "ETH", "XIN"}
 var quoteSymbols = []string{"BTC", "USDT"}
 
+var storeFlag = cli.StringFlag{Name: "store", Value: ant.StoreMySQL, Usage: "mysql, sqlite3 or memory"}
+var dsnFlag = cli.StringFlag{Name: "dsn", Value: "root:@/test?parseTime=true", Usage: "mysql dsn or sqlite file"}
+
+//sql存储打开时升级到最新的schema，migrate命令自己管理版本
+func openStore(c *cli.Context, migrate bool) (ant.Store, error) {
+	store, err := ant.OpenStore(c.String("store"), c.String("dsn"))
+	if err != nil {
+		return nil, err
+	}
+	if db := store.DB(); db != nil && migrate {
+		if err := ant.Migrate(db, -1); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+//--from和--to指定的日期范围，包含最后一天，默认最近7天
+func parseDays(c *cli.Context) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if v := c.String("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return to, to, err
+		}
+		to = t
+	}
+	to = to.AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -7)
+	if v := c.String("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, err
+		}
+		from = t
+	}
+	return from, to, nil
+}
+
 func main() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
@@ -30,13 +72,46 @@ func main() {
 			Usage: "find profits between different exchanges",
 			Flags: []cli.Flag{
 				cli.StringFlag{Name: "pair"},
+				storeFlag,
+				dsnFlag,
 				cli.BoolFlag{Name: "ocean"},
 				cli.BoolFlag{Name: "exin"},
+				cli.BoolFlag{Name: "triangle"},
+				cli.BoolFlag{Name: "maker"},
+				cli.Float64Flag{Name: "spread", Value: 0.008},
+				cli.Float64Flag{Name: "skew", Value: 0.5},
+				cli.DurationFlag{Name: "book-age", Value: ant.MaxDataAge.BookAge},
+				cli.DurationFlag{Name: "quote-age", Value: ant.MaxDataAge.QuoteAge},
+				cli.DurationFlag{Name: "event-lag", Value: ant.MaxDataAge.EventLag},
+				cli.DurationFlag{Name: "shutdown-timeout", Value: ant.ShutdownTimeout},
+				cli.DurationFlag{Name: "spread-interval", Value: ant.SpreadInterval},
+				cli.StringSliceFlag{Name: "policy", Usage: "order type per strategy, e.g. arbitrage=market, fishing=limit:30s"},
+				cli.StringSliceFlag{Name: "admin", Usage: "user id allowed to run admin commands and receive alerts"},
+				cli.StringFlag{Name: "notice-format", Value: ant.NoticeFormat, Usage: "post, card or text"},
+				cli.StringFlag{Name: "templates", Usage: "directory with opportunity.md, opportunity.json or opportunity.txt overriding the built-in notice templates"},
 			},
 			Action: func(c *cli.Context) error {
 				pair := c.String("pair")
 				ocean := c.Bool("ocean")
 				exin := c.Bool("exin")
+				ant.MaxDataAge.BookAge = c.Duration("book-age")
+				ant.MaxDataAge.QuoteAge = c.Duration("quote-age")
+				ant.MaxDataAge.EventLag = c.Duration("event-lag")
+				ant.SpreadInterval = c.Duration("spread-interval")
+				ant.Admins = append(ant.Admins, c.StringSlice("admin")...)
+				ant.NoticeFormat = c.String("notice-format")
+				//先设置模板目录，启动时检查的是实际使用的模板
+				ant.NoticeTemplateDir = c.String("templates")
+				if _, _, err := ant.RenderNotice(ant.NoticeFormat, &ant.NoticeData{}); err != nil {
+					return fmt.Errorf("notice format %s: %v", ant.NoticeFormat, err)
+				}
+				for _, spec := range c.StringSlice("policy") {
+					strategy, policy, err := ant.ParseOrderPolicy(spec)
+					if err != nil {
+						return err
+					}
+					ant.OrderPolicies[strategy] = policy
+				}
 				symbols := strings.Split(pair, "/")
 				var baseSymbol, quoteSymbol string
 				if len(symbols) == 2 {
@@ -48,31 +123,27 @@ func main() {
 					quoteSymbols = []string{quoteSymbol}
 				}
 
-				db, err := gorm.Open("mysql", "root:@/test?parseTime=true")
+				store, err := openStore(c, true)
 				if err != nil {
 					panic(err)
 				}
-				db.AutoMigrate(&ant.Snapshot{})
-				db.AutoMigrate(&ant.ProfitEvent{})
-
-				redisClient := redis.NewClient(&redis.Options{
-					DB:           1,
-					Addr:         "127.0.0.1:6379",
-					ReadTimeout:  3 * time.Second,
-					WriteTimeout: 3 * time.Second,
-					PoolTimeout:  4 * time.Second,
-					IdleTimeout:  60 * time.Second,
-					PoolSize:     1024,
-				})
+				defer store.Close()
 
 				ctx, cancel := context.WithCancel(context.Background())
-				ctx = ant.SetDB(ctx, db)
-				ctx = ant.SetupRedis(ctx, redisClient)
+				ctx = ant.SetStore(ctx, store)
+				//策略使用单独的context，退出时先停止策略，snapshot轮询和对冲继续运行
+				tradeCtx, stop := context.WithCancel(ctx)
 
 				bot := ant.NewAnt(ocean, exin)
+				if err := bot.LoadControls(ctx); err != nil {
+					panic(err)
+				}
 				go bot.PollMixinNetwork(ctx)
 				go bot.PollMixinMessage(ctx)
 				go bot.UpdateBalance(ctx)
+				go bot.OnExpire(ctx)
+				go bot.RecordValuations(ctx)
+				go bot.RecordSpreads(ctx)
 				for _, baseSymbol := range baseSymbols {
 					for _, quoteSymbol := range quoteSymbols {
 						base := ant.GetAssetId(strings.ToUpper(baseSymbol))
@@ -84,19 +155,218 @@ func main() {
 						client := ant.NewClient(ctx, base, quote, bot.OnOrderMessage(base, quote))
 						go client.PollOceanMessage(ctx)
 
-						go bot.Watching(ctx, base, quote)
-						go bot.Fishing(ctx, base, quote)
+						go bot.Watching(tradeCtx, base, quote)
+						go bot.Fishing(tradeCtx, base, quote)
+						if c.Bool("maker") {
+							config := ant.NewMakerConfig(base)
+							config.Spread = c.Float64("spread")
+							config.Skew = c.Float64("skew")
+							go bot.Making(tradeCtx, base, quote, config)
+						}
 					}
 				}
-				go bot.Trade(ctx)
+				go bot.Trade(tradeCtx)
+				if c.Bool("triangle") {
+					go bot.Triangle(tradeCtx, ant.USDT, ant.BTC)
+				}
+
+				//ctrl-c 退出时先撤单，等待退款和对冲完成；再次ctrl-c立即退出
+				<-sig
+				log.Println("+++exit because ctrl-c, press again to force++++")
+				go func() {
+					<-sig
+					log.Println("+++force exit++++")
+					os.Exit(1)
+				}()
+				stop()
+				bot.Shutdown(ctx, c.Duration("shutdown-timeout"))
+				cancel()
+				return nil
+			},
+		},
+		{
+			Name:  "report",
+			Usage: "show realized profit and loss",
+			Flags: []cli.Flag{
+				storeFlag,
+				dsnFlag,
+				cli.StringFlag{Name: "from", Usage: "first day, 2006-01-02"},
+				cli.StringFlag{Name: "to", Usage: "last day, 2006-01-02"},
+				cli.StringFlag{Name: "by", Value: "day", Usage: "group by day, pair, strategy, comma separated"},
+			},
+			Action: func(c *cli.Context) error {
+				from, to, err := parseDays(c)
+				if err != nil {
+					return err
+				}
+
+				store, err := openStore(c, false)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+				ctx := ant.SetStore(context.Background(), store)
+
+				summaries, err := ant.QueryPnL(ctx, from, to, strings.Split(c.String("by"), ",")...)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%-10s %-10s %-10s %6s %16s %16s\n", "DAY", "PAIR", "STRATEGY", "EVENTS", "FEE", "PNL")
+				for _, s := range summaries {
+					fmt.Printf("%-10s %-10s %-10s %6d %16s %16s\n", s.Day, s.Pair, s.Strategy, s.Events, s.Fee.StringFixed(4), s.Reference.StringFixed(4))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "upgrade or roll back the database schema",
+			Flags: []cli.Flag{
+				storeFlag,
+				dsnFlag,
+				cli.IntFlag{Name: "to", Value: -1, Usage: "target version, latest by default"},
+				cli.BoolFlag{Name: "status", Usage: "only show migrations"},
+				cli.StringFlag{Name: "import-redis", Usage: "redis address, import the subscribers kept there by older versions"},
+			},
+			Action: func(c *cli.Context) error {
+				store, err := openStore(c, false)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+				db := store.DB()
+				if db == nil {
+					return fmt.Errorf("%s store has no schema", c.String("store"))
+				}
 
-				//ctrl-c 退出时先取消订单
-				select {
-				case <-sig:
-					cancel()
-					bot.Clean()
-					return nil
+				if !c.Bool("status") {
+					if err := ant.Migrate(db, c.Int("to")); err != nil {
+						return err
+					}
+				}
+				if addr := c.String("import-redis"); addr != "" {
+					client := redis.NewClient(&redis.Options{Addr: addr})
+					defer client.Close()
+					count, err := ant.ImportRedisSubscribers(store, client)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%d subscribers imported from redis\n", count)
+				}
+				lines, err := ant.MigrationStatus(db)
+				if err != nil {
+					return err
+				}
+				for _, line := range lines {
+					fmt.Println(line)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "export profit events, snapshots or ledger entries",
+			Flags: []cli.Flag{
+				storeFlag,
+				dsnFlag,
+				cli.StringFlag{Name: "kind", Value: ant.ExportEvents, Usage: "events, snapshots or ledger"},
+				cli.StringFlag{Name: "format", Value: ant.ExportCSV, Usage: "csv or jsonl"},
+				cli.StringFlag{Name: "from", Usage: "first day, 2006-01-02"},
+				cli.StringFlag{Name: "to", Usage: "last day, 2006-01-02"},
+				cli.StringFlag{Name: "pair", Usage: "e.g. BTC/USDT"},
+				cli.StringFlag{Name: "state", Usage: "unfilled, filled or hedged"},
+				cli.StringFlag{Name: "strategy"},
+				cli.StringFlag{Name: "venue", Usage: "ocean or exin, snapshots and ledger only"},
+				cli.StringFlag{Name: "out", Usage: "output file, stdout by default"},
+			},
+			Action: func(c *cli.Context) error {
+				from, to, err := parseDays(c)
+				if err != nil {
+					return err
+				}
+				filter := ant.ExportFilter{
+					From:     from,
+					To:       to,
+					State:    c.String("state"),
+					Strategy: c.String("strategy"),
+					Venue:    c.String("venue"),
+				}
+				if pair := c.String("pair"); pair != "" {
+					symbols := strings.Split(strings.ToUpper(pair), "/")
+					if len(symbols) != 2 {
+						return fmt.Errorf("invalid pair %s", pair)
+					}
+					filter.Base, filter.Quote = ant.GetAssetId(symbols[0]), ant.GetAssetId(symbols[1])
+					if filter.Base == "" || filter.Quote == "" {
+						return fmt.Errorf("unknown pair %s", pair)
+					}
+				}
+
+				store, err := openStore(c, false)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+				ctx := ant.SetStore(context.Background(), store)
+
+				out := os.Stdout
+				if name := c.String("out"); name != "" {
+					f, err := os.Create(name)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					out = f
+				}
+				return ant.Export(ctx, out, c.String("kind"), c.String("format"), filter)
+			},
+		},
+		{
+			Name:  "spreads",
+			Usage: "show spread percentiles and how long spreads stay above a threshold",
+			Flags: []cli.Flag{
+				storeFlag,
+				dsnFlag,
+				cli.StringFlag{Name: "pair", Value: "BTC/USDT"},
+				cli.StringFlag{Name: "side", Value: ant.PageSideBid, Usage: "BID sells on Ocean ONE, ASK buys on Ocean ONE"},
+				cli.StringFlag{Name: "resolution", Value: ant.SpreadMinute, Usage: "raw, 1m or 1h"},
+				cli.StringFlag{Name: "from", Usage: "first day, 2006-01-02"},
+				cli.StringFlag{Name: "to", Usage: "last day, 2006-01-02"},
+				cli.Float64Flag{Name: "above", Value: ant.ProfitThreshold},
+			},
+			Action: func(c *cli.Context) error {
+				from, to, err := parseDays(c)
+				if err != nil {
+					return err
+				}
+				symbols := strings.Split(strings.ToUpper(c.String("pair")), "/")
+				if len(symbols) != 2 || ant.GetAssetId(symbols[0]) == "" || ant.GetAssetId(symbols[1]) == "" {
+					return fmt.Errorf("invalid pair %s", c.String("pair"))
+				}
+				base, quote := ant.GetAssetId(symbols[0]), ant.GetAssetId(symbols[1])
+
+				store, err := openStore(c, false)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+				ctx := ant.SetStore(context.Background(), store)
+
+				side, resolution := strings.ToUpper(c.String("side")), c.String("resolution")
+				ps := []float64{0.5, 0.9, 0.99, 1}
+				values, err := ant.SpreadPercentiles(ctx, base, quote, side, resolution, from, to, ps...)
+				if err != nil {
+					return err
+				}
+				for i, p := range ps {
+					fmt.Printf("p%-4v %s\n", p*100, values[i].StringFixed(6))
+				}
+				above, err := ant.SpreadAbove(ctx, base, quote, side, resolution, decimal.NewFromFloat(c.Float64("above")), from, to)
+				if err != nil {
+					return err
 				}
+				fmt.Printf("above %v: %.2f%% of samples, %d times, average %v, longest %v\n", c.Float64("above"), above.Ratio*100, above.Episodes, above.Average, above.Longest)
+				return nil
 			},
 		},
 	}
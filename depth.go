@@ -16,6 +16,8 @@ type Order struct {
 	Amount decimal.Decimal
 	Min    decimal.Decimal
 	Max    decimal.Decimal
+	//报价获取的时间，只有exin的报价有
+	UpdatedAt time.Time
 }
 
 type Depth struct {
@@ -51,6 +53,7 @@ func GetExinDepth(ctx context.Context, base, quote string) (*Depth, error) {
 }
 
 func GetExinOrder(ctx context.Context, base, quote string) (*Order, error) {
+	requestedAt := time.Now()
 	url := "https://exinone.com/exincore/markets" + fmt.Sprintf("?&base_asset=%s", quote)
 	client := http.Client{
 		Timeout: 10 * time.Second,
@@ -86,7 +89,7 @@ func GetExinOrder(ctx context.Context, base, quote string) (*Order, error) {
 			price, _ := decimal.NewFromString(v.Price)
 			min, _ := decimal.NewFromString(v.Min)
 			max, _ := decimal.NewFromString(v.Max)
-			return &Order{Price: price, Max: max, Min: min}, nil
+			return &Order{Price: price, Max: max, Min: min, UpdatedAt: requestedAt}, nil
 		}
 	}
 	return nil, fmt.Errorf("not found.")
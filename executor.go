@@ -0,0 +1,312 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//exin上的一笔对冲单，分摊到每个ProfitEvent各记一行
+type HedgeOrder struct {
+	ID        string          `json:"id"               gorm:"type:varchar(36);primary_key"`
+	TraceId   string          `json:"trace_id"         gorm:"type:varchar(36);index"`
+	EventId   string          `json:"event_id"         gorm:"type:varchar(36);index"`
+	Side      string          `json:"side"             gorm:"type:varchar(10)"`
+	Base      string          `json:"base"             gorm:"type:varchar(36)"`
+	Quote     string          `json:"quote"            gorm:"type:varchar(36)"`
+	Amount    decimal.Decimal `json:"amount"           gorm:"type:varchar(36)"`
+	Price     decimal.Decimal `json:"price"            gorm:"type:varchar(36)"`
+	Residual  bool            `json:"residual"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (HedgeOrder) TableName() string {
+	return "ant_hedge_orders"
+}
+
+//一个ProfitEvent需要对冲的数量
+type hedgeShare struct {
+	EventId string
+	Amount  decimal.Decimal
+}
+
+type hedgeJob struct {
+	side   string
+	base   string
+	quote  string
+	shares []hedgeShare
+	//每一笔的trace由key和序号生成，失败重试时保持不变
+	key      string
+	offset   int
+	residual bool
+	//下单前登记trace和这一笔分摊到各个event的数量，保证exin的返回能匹配上
+	pending func(trace string, shares []hedgeShare)
+}
+
+func (job *hedgeJob) total() decimal.Decimal {
+	total := decimal.Zero
+	for _, share := range job.shares {
+		total = total.Add(share.Amount)
+	}
+	return total
+}
+
+//第i笔对冲的trace
+func (job *hedgeJob) trace(i int) string {
+	return UuidWithString(job.key + strconv.Itoa(job.offset+i))
+}
+
+//exin当前对send资产的交易限额和价格
+func exinLimits(otc *Depth, side string) (min, max, price decimal.Decimal, err error) {
+	orders := otc.Asks
+	if side == PageSideAsk {
+		orders = otc.Bids
+	}
+	if len(orders) == 0 {
+		return min, max, price, fmt.Errorf("exin has no %s quote", side)
+	}
+	order := orders[0]
+	min, max, price = order.Min, order.Max, order.Price
+	if side == PageSideBid {
+		min, max = min.Mul(price), max.Mul(price)
+	}
+	return min, max, price, nil
+}
+
+//把job中的数量按exin限额拆成多笔依次下单，每一笔之前重新报价；
+//返回成功的trace和对冲掉的数量，不足最小交易量的余数留给调用方
+func (ant *Ant) executeHedge(ctx context.Context, job *hedgeJob) ([]string, decimal.Decimal, error) {
+	traces := make([]string, 0)
+	total, hedged := job.total(), decimal.Zero
+	for i := 0; total.Sub(hedged).IsPositive(); i++ {
+		otc, err := GetExinDepth(ctx, job.base, job.quote)
+		if err != nil {
+			return traces, hedged, err
+		}
+		min, max, price, err := exinLimits(otc, job.side)
+		if err != nil {
+			return traces, hedged, err
+		}
+
+		amount := total.Sub(hedged)
+		if amount.LessThan(min) {
+			break
+		}
+		if max.IsPositive() && amount.GreaterThan(max) {
+			amount = max
+		}
+
+		trace := job.trace(i)
+		if job.pending != nil {
+			job.pending(trace, allocate(job.shares, hedged, amount))
+		}
+		if ant.enableExin {
+			if _, err := ExinTrade(job.side, amount.String(), job.base, job.quote, trace); err != nil {
+				return traces, hedged, err
+			}
+		} else {
+			log.Printf("hedge %s %v, %s/%s", job.side, amount, Who(job.base), Who(job.quote))
+		}
+
+		ant.recordHedge(ctx, job, trace, price, hedged, amount)
+		traces = append(traces, trace)
+		hedged = hedged.Add(amount)
+	}
+	return traces, hedged, nil
+}
+
+//把一笔对冲单分摊到各个event记录下来
+func (ant *Ant) recordHedge(ctx context.Context, job *hedgeJob, trace string, price, from, amount decimal.Decimal) {
+	for _, share := range allocate(job.shares, from, amount) {
+		order := HedgeOrder{
+			ID:        UuidWithString(trace + share.EventId),
+			TraceId:   trace,
+			EventId:   share.EventId,
+			Side:      job.side,
+			Base:      job.base,
+			Quote:     job.quote,
+			Amount:    share.Amount,
+			Price:     price,
+			Residual:  job.residual,
+			CreatedAt: time.Now(),
+		}
+		if err := Storage(ctx).SaveHedgeOrder(&order); err != nil {
+			log.Println("create hedge order error", err)
+		}
+	}
+}
+
+//把从from开始的amount分摊到各个share
+func allocate(shares []hedgeShare, from, amount decimal.Decimal) []hedgeShare {
+	result := make([]hedgeShare, 0)
+	start, end := decimal.Zero, from.Add(amount)
+	for _, share := range shares {
+		stop := start.Add(share.Amount)
+		lower, upper := start, stop
+		if from.GreaterThan(lower) {
+			lower = from
+		}
+		if end.LessThan(upper) {
+			upper = end
+		}
+		if upper.GreaterThan(lower) {
+			result = append(result, hedgeShare{EventId: share.EventId, Amount: upper.Sub(lower)})
+		}
+		start = stop
+	}
+	return result
+}
+
+//不足exin最小交易量的余数，按资产和对冲方向攒起来
+type residual struct {
+	side    string
+	base    string
+	quote   string
+	shares  []hedgeShare
+	hedging bool
+	//余数来自的position，已经结束，对冲单返回时记到它们的event上
+	positions map[string]*position
+	//当前第一笔余数对应的trace key和已经用掉的序号，同一个key下每一笔对冲的trace都不同
+	key    string
+	offset int
+}
+
+func (ant *Ant) addResidual(side, base, quote string, p *position) {
+	ant.residualsLock.Lock()
+	defer ant.residualsLock.Unlock()
+	key := side + base + quote
+	r, ok := ant.residuals[key]
+	if !ok {
+		r = &residual{side: side, base: base, quote: quote, positions: make(map[string]*position, 0)}
+		ant.residuals[key] = r
+	}
+	r.shares = append(r.shares, hedgeShare{EventId: p.event.ID, Amount: p.filled})
+	r.positions[p.event.ID] = p
+}
+
+//按当前的余数生成对冲任务，调用时需要持有residualsLock
+func (r *residual) job() *hedgeJob {
+	shares := make([]hedgeShare, len(r.shares))
+	copy(shares, r.shares)
+	if key := shares[0].EventId + r.side + ExinCore; key != r.key {
+		r.key, r.offset = key, 0
+	}
+	return &hedgeJob{
+		side:     r.side,
+		base:     r.base,
+		quote:    r.quote,
+		shares:   shares,
+		key:      r.key,
+		offset:   r.offset,
+		residual: true,
+	}
+}
+
+//余数的对冲任务，每一笔下单前把trace登记到分摊的各个position上，调用时需要持有residualsLock
+func (ant *Ant) residualJob(r *residual) *hedgeJob {
+	job := r.job()
+	positions := make(map[string]*position, len(r.positions))
+	for id, p := range r.positions {
+		positions[id] = p
+	}
+	job.pending = func(trace string, shares []hedgeShare) {
+		ant.registry.Lock()
+		defer ant.registry.Unlock()
+		ant.registry.addResidual(trace, shares, positions)
+	}
+	return job
+}
+
+//扣掉已经对冲的数量，跳过发出去的trace；失败的那一笔不计入，下次用同一个trace重试
+func (r *residual) settle(traces []string, hedged decimal.Decimal) {
+	r.offset += len(traces)
+	left := make([]hedgeShare, 0)
+	for _, share := range r.shares {
+		if hedged.GreaterThanOrEqual(share.Amount) {
+			hedged = hedged.Sub(share.Amount)
+			continue
+		}
+		share.Amount = share.Amount.Sub(hedged)
+		hedged = decimal.Zero
+		left = append(left, share)
+	}
+	r.shares = left
+	//对冲完的position只需要留在registry里等待返回
+	for id := range r.positions {
+		found := false
+		for _, share := range left {
+			found = found || share.EventId == id
+		}
+		if !found {
+			delete(r.positions, id)
+		}
+	}
+}
+
+//余数攒够exin最小交易量后一起对冲
+func (ant *Ant) flushResiduals(ctx context.Context) {
+	ant.residualsLock.Lock()
+	jobs := make(map[*residual]*hedgeJob, 0)
+	for _, r := range ant.residuals {
+		if r.hedging || len(r.shares) == 0 {
+			continue
+		}
+		r.hedging = true
+		jobs[r] = ant.residualJob(r)
+	}
+	ant.residualsLock.Unlock()
+
+	for r, job := range jobs {
+		traces, hedged, err := ant.executeHedge(ctx, job)
+		if err != nil {
+			log.Println("hedge residual error", err)
+			ant.registry.Lock()
+			ant.registry.removeResidual(job.trace(len(traces)))
+			ant.registry.Unlock()
+		}
+
+		ant.residualsLock.Lock()
+		r.hedging = false
+		r.settle(traces, hedged)
+		ant.residualsLock.Unlock()
+	}
+}
+
+//余数对冲单返回的数量按分摊的比例记到各个event上，这些position已经结束，直接写入数据库
+func (ant *Ant) settleResidual(ctx context.Context, s *Snapshot, trace string, shares []residualShare) error {
+	amount, _ := decimal.NewFromString(s.Amount)
+	total := decimal.Zero
+	for _, share := range shares {
+		total = total.Add(share.amount)
+	}
+	if !amount.IsPositive() || !total.IsPositive() {
+		return nil
+	}
+
+	ant.registry.Lock()
+	ant.registry.removeResidual(trace)
+	updates := make(map[string]map[string]interface{}, 0)
+	for _, share := range shares {
+		event := share.p.event
+		part := amount.Mul(share.amount).Div(total)
+		if s.AssetId == event.Base {
+			event.BaseAmount = event.BaseAmount.Add(part)
+		} else if s.AssetId == event.Quote {
+			event.QuoteAmount = event.QuoteAmount.Add(part)
+		}
+		event.OtcOrder = trace
+		updates[event.ID] = map[string]interface{}{"base_amount": event.BaseAmount, "quote_amount": event.QuoteAmount, "otc_order": event.OtcOrder}
+	}
+	ant.registry.Unlock()
+
+	for id, u := range updates {
+		if err := Storage(ctx).UpdateEvent(id, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package ant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func dec(s string) decimal.Decimal {
+	v, err := decimal.NewFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestAllocate(t *testing.T) {
+	shares := []hedgeShare{{"a", dec("1")}, {"b", dec("2")}, {"c", dec("3")}}
+	cases := []struct {
+		from, amount string
+		want         []hedgeShare
+	}{
+		{"0", "6", []hedgeShare{{"a", dec("1")}, {"b", dec("2")}, {"c", dec("3")}}},
+		{"0", "0.5", []hedgeShare{{"a", dec("0.5")}}},
+		{"0.5", "1", []hedgeShare{{"a", dec("0.5")}, {"b", dec("0.5")}}},
+		{"1", "2", []hedgeShare{{"b", dec("2")}}},
+		{"2.5", "3.5", []hedgeShare{{"b", dec("0.5")}, {"c", dec("3")}}},
+		//超出的部分不分摊
+		{"5", "4", []hedgeShare{{"c", dec("1")}}},
+		{"6", "1", []hedgeShare{}},
+	}
+	for _, c := range cases {
+		got := allocate(shares, dec(c.from), dec(c.amount))
+		if len(got) != len(c.want) {
+			t.Fatalf("allocate(%s, %s) = %v, want %v", c.from, c.amount, got, c.want)
+		}
+		for i := range got {
+			if got[i].EventId != c.want[i].EventId || !got[i].Amount.Equal(c.want[i].Amount) {
+				t.Fatalf("allocate(%s, %s) = %v, want %v", c.from, c.amount, got, c.want)
+			}
+		}
+	}
+}
+
+func TestResidualSettle(t *testing.T) {
+	r := &residual{side: PageSideAsk, base: BTC, quote: USDT}
+	r.shares = []hedgeShare{{"a", dec("1")}, {"b", dec("2")}}
+
+	first := r.job()
+	if first.offset != 0 {
+		t.Fatalf("first offset %d, want 0", first.offset)
+	}
+	//对冲了一笔，a还剩一半
+	r.settle([]string{first.trace(0)}, dec("0.5"))
+	if len(r.shares) != 2 || !r.shares[0].Amount.Equal(dec("0.5")) {
+		t.Fatalf("shares after first flush %v", r.shares)
+	}
+
+	//剩下的余数不能再用已经发出去的trace
+	second := r.job()
+	if second.key != first.key || second.offset != 1 {
+		t.Fatalf("second job key %s offset %d, want %s 1", second.key, second.offset, first.key)
+	}
+	if second.trace(0) == first.trace(0) {
+		t.Fatal("second flush reuses the first trace")
+	}
+
+	//失败的那一笔不计入，重试时trace不变
+	r.settle(nil, decimal.Zero)
+	if retry := r.job(); retry.trace(0) != second.trace(0) {
+		t.Fatal("retry changes the trace")
+	}
+
+	//a对冲完，换成b的key重新编号
+	r.settle([]string{second.trace(0), second.trace(1)}, dec("1.5"))
+	if len(r.shares) != 1 || r.shares[0].EventId != "b" || !r.shares[0].Amount.Equal(dec("1")) {
+		t.Fatalf("shares after second flush %v", r.shares)
+	}
+	if third := r.job(); third.key == first.key || third.offset != 0 {
+		t.Fatalf("third job key %s offset %d", third.key, third.offset)
+	}
+}
+
+//余数对冲单的返回按分摊的数量记到各个event上
+func TestResidualReturn(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := SetStore(context.Background(), store)
+	ant := NewAnt(false, false)
+
+	positions := make([]*position, 0)
+	for _, id := range []string{"a", "b"} {
+		event := &ProfitEvent{ID: UuidWithString(id), Category: PageSideBid, Base: BTC, Quote: USDT}
+		if err := store.SaveEvent(event); err != nil {
+			t.Fatal(err)
+		}
+		p := newPosition(event, true)
+		p.filled = dec("0.001")
+		if id == "b" {
+			p.filled = dec("0.003")
+		}
+		positions = append(positions, p)
+		ant.addResidual(PageSideAsk, BTC, USDT, p)
+	}
+
+	ant.residualsLock.Lock()
+	job := ant.residualJob(ant.residuals[PageSideAsk+BTC+USDT])
+	ant.residualsLock.Unlock()
+	trace := job.trace(0)
+	job.pending(trace, allocate(job.shares, decimal.Zero, job.total()))
+
+	s := &Snapshot{SnapshotId: UuidWithString("return"), Amount: "40", TraceId: trace, OpponentId: ExinCore}
+	s.AssetId = USDT
+	if err := ant.HandleSnapshot(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"10", "30"} {
+		event, err := store.FindEvent(positions[i].event.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !event.QuoteAmount.Equal(dec(want)) || event.OtcOrder != trace {
+			t.Fatalf("event %d got %v %s, want %s %s", i, event.QuoteAmount, event.OtcOrder, want, trace)
+		}
+	}
+	if _, _, ok := ant.registry.byResidual(trace); ok {
+		t.Fatal("returned residual trace still registered")
+	}
+}
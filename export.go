@@ -0,0 +1,283 @@
+package ant
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ExportEvents    = "events"
+	ExportSnapshots = "snapshots"
+	ExportLedger    = "ledger"
+
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+
+	//ProfitEvent的状态，由成交和对冲的结果推出
+	EventStateUnfilled = "unfilled"
+	EventStateFilled   = "filled"
+	EventStateHedged   = "hedged"
+)
+
+//导出的时间范围是[From, To)，其余条件为空时不过滤
+type ExportFilter struct {
+	From     time.Time
+	To       time.Time
+	Base     string
+	Quote    string
+	State    string
+	Strategy string
+	Venue    string
+}
+
+func EventState(e *ProfitEvent) string {
+	if e.OtcOrder != "" {
+		return EventStateHedged
+	}
+	if !e.BaseAmount.IsZero() || !e.QuoteAmount.IsZero() {
+		return EventStateFilled
+	}
+	return EventStateUnfilled
+}
+
+//event之外的记录按关联的event过滤交易对、策略和状态
+func (filter ExportFilter) matches(e *ProfitEvent) bool {
+	if filter.Base != "" && filter.Quote != "" && (e.Base != filter.Base || e.Quote != filter.Quote) {
+		return false
+	}
+	if filter.Strategy != "" && filter.Strategy != e.Strategy {
+		return false
+	}
+	return filter.State == "" || filter.State == EventState(e)
+}
+
+func (filter ExportFilter) linked() bool {
+	return filter.Base != "" || filter.Quote != "" || filter.Strategy != "" || filter.State != ""
+}
+
+//按kind导出到w，数量保留原始精度；对kind没有意义的条件直接报错，不会被忽略
+func Export(ctx context.Context, w io.Writer, kind, format string, filter ExportFilter) error {
+	if kind == ExportEvents && filter.Venue != "" {
+		return fmt.Errorf("venue filter does not apply to %s", kind)
+	}
+	db := Database(ctx)
+	if db == nil {
+		return fmt.Errorf("export needs a sql store")
+	}
+	db = db.Where("created_at >= ? AND created_at < ?", filter.From, filter.To)
+
+	var header []string
+	var rows [][]string
+	var err error
+	switch kind {
+	case ExportEvents:
+		header, rows, err = exportEvents(db, filter)
+	case ExportSnapshots:
+		header, rows, err = exportSnapshots(db, filter)
+	case ExportLedger:
+		header, rows, err = exportLedger(db, filter)
+	default:
+		return fmt.Errorf("unknown export %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+	case ExportJSONL:
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			object := make(map[string]string, len(header))
+			for i, column := range header {
+				object[column] = row[i]
+			}
+			if err := encoder.Encode(object); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return nil
+}
+
+func exportEvents(db *gorm.DB, filter ExportFilter) ([]string, [][]string, error) {
+	if filter.Base != "" && filter.Quote != "" {
+		db = db.Where("base=? AND quote=?", filter.Base, filter.Quote)
+	}
+	if filter.Strategy != "" {
+		db = db.Where("strategy=?", filter.Strategy)
+	}
+	var events []ProfitEvent
+	if err := db.Order("created_at").Find(&events).Error; err != nil {
+		return nil, nil, err
+	}
+
+	header := []string{"id", "created_at", "strategy", "state", "side", "base", "quote", "price", "amount", "profit", "base_amount", "quote_amount", "exchange_order", "otc_order"}
+	rows := make([][]string, 0, len(events))
+	for _, e := range events {
+		state := EventState(&e)
+		if filter.State != "" && filter.State != state {
+			continue
+		}
+		rows = append(rows, []string{
+			e.ID, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.Strategy, state, e.Category,
+			Who(e.Base), Who(e.Quote), e.Price.String(), e.Amount.String(), e.Profit.String(),
+			e.BaseAmount.String(), e.QuoteAmount.String(), e.ExchangeOrder, e.OtcOrder,
+		})
+	}
+	return header, rows, nil
+}
+
+func exportSnapshots(db *gorm.DB, filter ExportFilter) ([]string, [][]string, error) {
+	if filter.Venue != "" {
+		db = db.Where("venue=?", filter.Venue)
+	}
+	var snapshots []Snapshot
+	if err := db.Order("created_at").Find(&snapshots).Error; err != nil {
+		return nil, nil, err
+	}
+	var byOrder map[string]*ProfitEvent
+	if filter.linked() {
+		var err error
+		if byOrder, err = snapshotEvents(db.New(), snapshots); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	header := []string{"snapshot_id", "created_at", "venue", "reply_type", "asset", "amount", "price", "fee", "fee_asset", "trace_id", "order_id", "ask_order_id", "bid_order_id", "opponent_id", "data"}
+	rows := make([][]string, 0, len(snapshots))
+	for _, s := range snapshots {
+		if byOrder != nil {
+			var e *ProfitEvent
+			for _, id := range []string{s.TraceId, s.OrderId, s.AskOrderId, s.BidOrderId} {
+				if e = byOrder[id]; e != nil {
+					break
+				}
+			}
+			if e == nil || !filter.matches(e) {
+				continue
+			}
+		}
+		feeAsset := ""
+		if s.FeeAsset != "" {
+			feeAsset = Who(s.FeeAsset)
+		}
+		rows = append(rows, []string{
+			s.SnapshotId, s.CreatedAt.UTC().Format(time.RFC3339Nano), s.Venue, s.ReplyType, Who(s.AssetId),
+			s.Amount, s.Price, s.Fee, feeAsset, s.TraceId, s.OrderId, s.AskOrderId, s.BidOrderId, s.OpponentId, s.Data,
+		})
+	}
+	return header, rows, nil
+}
+
+//snapshot里的订单号对应的event：ocean的订单号是event的ExchangeOrder，exin的是对冲单的TraceId
+func snapshotEvents(db *gorm.DB, snapshots []Snapshot) (map[string]*ProfitEvent, error) {
+	ids := make([]string, 0, len(snapshots))
+	for _, s := range snapshots {
+		for _, id := range []string{s.TraceId, s.OrderId, s.AskOrderId, s.BidOrderId} {
+			if id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	byOrder := make(map[string]*ProfitEvent, 0)
+	if len(ids) == 0 {
+		return byOrder, nil
+	}
+
+	var events []ProfitEvent
+	if err := db.Where("exchange_order IN (?)", ids).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for i := range events {
+		byOrder[events[i].ExchangeOrder] = &events[i]
+	}
+
+	var hedges []HedgeOrder
+	if err := db.Where("trace_id IN (?)", ids).Find(&hedges).Error; err != nil {
+		return nil, err
+	}
+	eventIds := make([]string, 0, len(hedges))
+	for _, h := range hedges {
+		eventIds = append(eventIds, h.EventId)
+	}
+	var hedged []ProfitEvent
+	if len(eventIds) > 0 {
+		if err := db.Where("id IN (?)", eventIds).Find(&hedged).Error; err != nil {
+			return nil, err
+		}
+	}
+	byId := make(map[string]*ProfitEvent, len(hedged))
+	for i := range hedged {
+		byId[hedged[i].ID] = &hedged[i]
+	}
+	for _, h := range hedges {
+		if e, ok := byId[h.EventId]; ok {
+			byOrder[h.TraceId] = e
+		}
+	}
+	return byOrder, nil
+}
+
+func exportLedger(db *gorm.DB, filter ExportFilter) ([]string, [][]string, error) {
+	if filter.Venue != "" {
+		db = db.Where("venue=?", filter.Venue)
+	}
+	var entries []LedgerEntry
+	if err := db.Order("created_at").Find(&entries).Error; err != nil {
+		return nil, nil, err
+	}
+
+	//交易对、策略和状态的条件通过对应的event过滤
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.EventId)
+	}
+	var events []ProfitEvent
+	if len(ids) > 0 {
+		if err := db.New().Where("id IN (?)", ids).Find(&events).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	byId := make(map[string]*ProfitEvent, len(events))
+	for i := range events {
+		byId[events[i].ID] = &events[i]
+	}
+
+	header := []string{"id", "created_at", "event_id", "strategy", "pair", "venue", "asset", "amount", "fee", "fee_asset", "snapshot_id"}
+	rows := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		e, ok := byId[entry.EventId]
+		if !ok {
+			continue
+		}
+		if !filter.matches(e) {
+			continue
+		}
+		feeAsset := ""
+		if entry.FeeAsset != "" {
+			feeAsset = Who(entry.FeeAsset)
+		}
+		rows = append(rows, []string{
+			entry.ID, entry.CreatedAt.UTC().Format(time.RFC3339Nano), entry.EventId, e.Strategy,
+			Who(e.Base) + "/" + Who(e.Quote), entry.Venue, Who(entry.AssetId),
+			entry.Amount.String(), entry.Fee.String(), feeAsset, entry.SnapshotId,
+		})
+	}
+	return header, rows, nil
+}
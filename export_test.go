@@ -0,0 +1,67 @@
+package ant
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+//snapshot按关联的event过滤交易对和策略，和对kind没有意义的条件一起报错
+func TestExportSnapshotsByEvent(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	store := NewSQLStore(db)
+	ctx := SetStore(context.Background(), store)
+
+	now := time.Now()
+	for _, e := range []*ProfitEvent{
+		{ID: UuidWithString("btc"), Category: PageSideBid, Base: BTC, Quote: USDT, Strategy: StrategyTriangle, ExchangeOrder: UuidWithString("btc-order"), CreatedAt: now},
+		{ID: UuidWithString("eth"), Category: PageSideBid, Base: ETH, Quote: USDT, Strategy: StrategyTriangle, ExchangeOrder: UuidWithString("eth-order"), CreatedAt: now},
+	} {
+		if err := store.SaveEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hedge := &HedgeOrder{ID: UuidWithString("hedge"), TraceId: UuidWithString("hedge-trace"), EventId: UuidWithString("btc"), CreatedAt: now}
+	if err := store.SaveHedgeOrder(hedge); err != nil {
+		t.Fatal(err)
+	}
+	//三笔snapshot都是USDT，按资产过滤时都会算进BTC/USDT
+	for _, s := range []*Snapshot{
+		{SnapshotId: UuidWithString("s-btc"), AssetId: USDT, Amount: "-100", TraceId: UuidWithString("btc-order"), Venue: VenueOcean, CreatedAt: now},
+		{SnapshotId: UuidWithString("s-eth"), AssetId: USDT, Amount: "-100", TraceId: UuidWithString("eth-order"), Venue: VenueOcean, CreatedAt: now},
+		{SnapshotId: UuidWithString("s-hedge"), AssetId: USDT, Amount: "100", TraceId: UuidWithString("hedge-trace"), Venue: VenueExin, CreatedAt: now},
+	} {
+		if err := store.SaveSnapshot(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter := ExportFilter{From: now.Add(-time.Hour), To: now.Add(time.Hour), Base: BTC, Quote: USDT}
+	var out bytes.Buffer
+	if err := Export(ctx, &out, ExportSnapshots, ExportCSV, filter); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || strings.Contains(out.String(), UuidWithString("s-eth")) {
+		t.Fatalf("BTC/USDT snapshots exported as\n%s", out.String())
+	}
+
+	filter.Strategy = StrategyMaker
+	out.Reset()
+	if err := Export(ctx, &out, ExportSnapshots, ExportCSV, filter); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 1 {
+		t.Fatalf("maker snapshots exported as\n%s", out.String())
+	}
+
+	filter = ExportFilter{From: now.Add(-time.Hour), To: now.Add(time.Hour), Venue: VenueOcean}
+	if err := Export(ctx, &out, ExportEvents, ExportCSV, filter); err == nil {
+		t.Fatal("venue filter accepted for events")
+	}
+}
@@ -0,0 +1,211 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//exin持续无法对冲超过该时间后，在ocean.one上强制平仓
+	HedgeRetryWindow = 30 * time.Second
+	//平仓价在模拟成交的最差价格上再让出的滑点
+	FallbackSlippage = 0.005
+	//预估亏损超过成本的该比例时不平仓，继续等待exin
+	FallbackMaxLoss = 0.05
+)
+
+//平仓时可以经过的中间资产
+var FallbackIntermediates = []string{USDT, BTC}
+
+//exin无法对冲时在ocean.one上强制平仓的记录，亏损以原订单付出的资产计，Stuck为中间资产没有换回的数量
+type Unwind struct {
+	ID        string          `json:"id"               gorm:"type:varchar(36);primary_key"`
+	EventId   string          `json:"event_id"         gorm:"type:varchar(36);index"`
+	Route     string          `json:"route"            gorm:"type:varchar(64)"`
+	Asset     string          `json:"asset"            gorm:"type:varchar(36)"`
+	Amount    decimal.Decimal `json:"amount"           gorm:"type:varchar(36)"`
+	Cost      decimal.Decimal `json:"cost"             gorm:"type:varchar(36)"`
+	Returned  decimal.Decimal `json:"returned"         gorm:"type:varchar(36)"`
+	Loss      decimal.Decimal `json:"loss"             gorm:"type:varchar(36)"`
+	Stuck     decimal.Decimal `json:"stuck"            gorm:"type:varchar(36)"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (Unwind) TableName() string {
+	return "ant_unwinds"
+}
+
+type unwindRoute []Leg
+
+func (route unwindRoute) String() string {
+	str := Who(route[0].From())
+	for _, leg := range route {
+		str += ">" + Who(leg.To())
+	}
+	return str
+}
+
+//from换成to的路径，直接兑换或经过一个中间资产
+func (ant *Ant) unwindRoutes(from, to string) []unwindRoute {
+	routes := make([]unwindRoute, 0)
+	if leg, ok := ant.directLeg(from, to); ok {
+		routes = append(routes, unwindRoute{leg})
+	}
+	for _, mid := range FallbackIntermediates {
+		if mid == from || mid == to {
+			continue
+		}
+		first, ok := ant.directLeg(from, mid)
+		if !ok {
+			continue
+		}
+		if second, ok := ant.directLeg(mid, to); ok {
+			routes = append(routes, unwindRoute{first, second})
+		}
+	}
+	return routes
+}
+
+//按深度模拟整条路径，返回预计得到的数量和每一步的挂单价格，深度不够时价格为nil
+func (ant *Ant) planRoute(route unwindRoute, amount decimal.Decimal) (decimal.Decimal, []decimal.Decimal) {
+	slippage := decimal.NewFromFloat(FallbackSlippage)
+	prices := make([]decimal.Decimal, 0, len(route))
+	hold := amount
+	for _, leg := range route {
+		book, ok := ant.books[leg.Base+"-"+leg.Quote]
+		if !ok {
+			return decimal.Zero, nil
+		}
+		used, output, worst := Simulate(book.GetDepth(TriangleDepth), leg.Side, hold)
+		if !worst.IsPositive() || used.LessThan(hold) {
+			return decimal.Zero, nil
+		}
+		price := worst.Mul(decimal.NewFromFloat(1).Add(slippage))
+		if leg.Side == PageSideAsk {
+			price = worst.Mul(decimal.NewFromFloat(1).Sub(slippage))
+		}
+		prices = append(prices, price)
+		hold = output
+	}
+	return hold, prices
+}
+
+//exin持续无法对冲时，把成交得到的资产在ocean.one上换回原来付出的资产
+func (ant *Ant) fallback(ctx context.Context, p *position) {
+	ant.registry.Lock()
+	if !p.otc || p.hedging || !p.filled.IsPositive() || p.failedAt.IsZero() || time.Since(p.failedAt) < HedgeRetryWindow {
+		ant.registry.Unlock()
+		return
+	}
+	event := p.event
+	amount := p.filled
+	_, from := p.hedgeSide()
+	to := event.Base
+	if event.Category == PageSideBid {
+		to = event.Quote
+	}
+	p.hedging = true
+	ant.registry.Unlock()
+
+	go func() {
+		used, err := ant.forceUnwind(ctx, event, from, to, amount)
+		if err != nil {
+			log.Println("fallback error", err)
+		}
+
+		ant.registry.Lock()
+		defer ant.registry.Unlock()
+		p.hedging = false
+		p.filled = p.filled.Sub(used)
+		//没有平完的部分继续尝试exin，再等一个窗口
+		if p.filled.IsPositive() {
+			p.failedAt = time.Now()
+		} else {
+			p.failedAt = time.Time{}
+		}
+	}()
+}
+
+//选预计得到最多的路径平仓，记录亏损并告警，返回卖出的数量
+func (ant *Ant) forceUnwind(ctx context.Context, event *ProfitEvent, from, to string, amount decimal.Decimal) (decimal.Decimal, error) {
+	pair := Who(event.Base) + "/" + Who(event.Quote)
+	cost := amount.Mul(event.Price)
+	if event.Category == PageSideAsk {
+		cost = amount.Div(event.Price)
+	}
+
+	var best unwindRoute
+	var prices []decimal.Decimal
+	output := decimal.Zero
+	for _, route := range ant.unwindRoutes(from, to) {
+		out, p := ant.planRoute(route, amount)
+		if p != nil && out.GreaterThan(output) {
+			best, prices, output = route, p, out
+		}
+	}
+	if best == nil {
+		ant.Alert(ctx, fmt.Sprintf("%s %v %s unhedged, no ocean route to %s", pair, amount, Who(from), Who(to)))
+		return decimal.Zero, fmt.Errorf("no unwind route for %s", event.ID)
+	}
+	if output.LessThan(cost.Mul(decimal.NewFromFloat(1 - FallbackMaxLoss))) {
+		ant.Alert(ctx, fmt.Sprintf("%s %v %s unhedged, unwind via %s returns %v of %v %s", pair, amount, Who(from), best, output, cost, Who(to)))
+		return decimal.Zero, fmt.Errorf("unwind loss too high for %s", event.ID)
+	}
+
+	id := uuid.Must(uuid.NewV4()).String()
+	hold, used, returned, stuck := amount, decimal.Zero, decimal.Zero, decimal.Zero
+	for i, leg := range best {
+		legEvent := newLegEvent(id, i, StrategyFallback, leg, prices[i], hold, decimal.Zero)
+		received, refund, err := ant.runLeg(ctx, legEvent, hold, true)
+		if err != nil {
+			if i > 0 {
+				stuck = stuck.Add(hold)
+			}
+			log.Println("unwind error", err)
+			break
+		}
+		if i == 0 {
+			used = hold.Sub(refund)
+		} else {
+			stuck = stuck.Add(refund)
+		}
+		if i == len(best)-1 {
+			returned = received
+		}
+		if !received.IsPositive() {
+			break
+		}
+		hold = received
+	}
+	if !used.IsPositive() {
+		return decimal.Zero, fmt.Errorf("unwind of %s not filled", event.ID)
+	}
+
+	spent := cost.Mul(used).Div(amount)
+	unwind := Unwind{
+		ID:        id,
+		EventId:   event.ID,
+		Route:     best.String(),
+		Asset:     to,
+		Amount:    used,
+		Cost:      spent,
+		Returned:  returned,
+		Loss:      spent.Sub(returned),
+		Stuck:     stuck,
+		CreatedAt: time.Now(),
+	}
+	if err := Storage(ctx).SaveUnwind(&unwind); err != nil {
+		log.Println("create unwind error", err)
+	}
+	msg := fmt.Sprintf("forced unwind %s %s, sold %v %s via %s, cost %v, returned %v, loss %v %s", pair, event.Category, used, Who(from), unwind.Route, spent, returned, unwind.Loss, Who(to))
+	if stuck.IsPositive() {
+		msg += fmt.Sprintf(", %v stuck in intermediate asset", stuck)
+	}
+	ant.Alert(ctx, msg)
+	return used, nil
+}
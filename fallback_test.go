@@ -0,0 +1,108 @@
+package ant
+
+import (
+	"context"
+	"testing"
+
+	bot "github.com/MixinNetwork/bot-api-go-client"
+	"github.com/shopspring/decimal"
+)
+
+//模拟ocean按限价全部成交，成交的snapshot同步交给HandleSnapshot
+func fakeFill(ctx context.Context, ant *Ant) func(side, price, amount, category, base, quote string, trace ...string) (string, error) {
+	return func(side, price, amount, category, base, quote string, trace ...string) (string, error) {
+		size, err := SizeSend(side, decimal.RequireFromString(price), decimal.RequireFromString(amount))
+		if err != nil {
+			return "", err
+		}
+		s := &Snapshot{SnapshotId: UuidWithString(trace[0] + "fill"), Amount: size.Receive().String(), TraceId: trace[0], OpponentId: OceanCore, ReplyType: ReplyMatch}
+		s.AssetId = quote
+		if side == PageSideBid {
+			s.AssetId = base
+		}
+		return trace[0], ant.HandleSnapshot(ctx, s)
+	}
+}
+
+//exin对冲失败后的强制平仓在退出过程中也要执行，并按实际成交记录亏损和告警
+func TestForceUnwindAfterClose(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := SetStore(context.Background(), store)
+	ant := NewAnt(false, false)
+	testBook(ant, BTC, USDT, dec("10000"), dec("1"))
+	ant.registry.Close()
+
+	defer func(trade func(side, price, amount, category, base, quote string, trace ...string) (string, error), admins []string) {
+		oceanTrade, Admins = trade, admins
+	}(oceanTrade, Admins)
+	oceanTrade = fakeFill(ctx, ant)
+	Admins = []string{"admin"}
+	var alerts []string
+	ant.send = func(ctx context.Context, view bot.MessageView, text string) error {
+		alerts = append(alerts, text)
+		return nil
+	}
+
+	event := &ProfitEvent{
+		ID:       UuidWithString("fallback"),
+		Category: PageSideBid,
+		Base:     BTC,
+		Quote:    USDT,
+		Price:    dec("10000"),
+	}
+	used, err := ant.forceUnwind(ctx, event, BTC, USDT, dec("0.01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !used.Equal(dec("0.01")) {
+		t.Fatalf("sold %v, want 0.01", used)
+	}
+
+	size, _ := SizeSend(PageSideAsk, dec("9950"), dec("0.01"))
+	unwinds := store.(*memoryStore).unwinds
+	if len(unwinds) != 1 {
+		t.Fatalf("%d unwinds recorded, want 1", len(unwinds))
+	}
+	u := unwinds[0]
+	if u.Asset != USDT || !u.Amount.Equal(dec("0.01")) || !u.Cost.Equal(dec("100")) || !u.Returned.Equal(size.Receive()) || !u.Loss.Equal(dec("100").Sub(size.Receive())) || !u.Stuck.IsZero() {
+		t.Fatalf("unwind recorded as %+v, returned want %v", u, size.Receive())
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("%d alerts sent, want 1", len(alerts))
+	}
+}
+
+//三角套利换回起始资产时按to记录实际换回的数量，成本和亏损按盘口价格计算
+func TestSettleLeftRecordsLoss(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := SetStore(context.Background(), store)
+	ant := NewAnt(false, false)
+	testBook(ant, BTC, USDT, dec("10000"), dec("1"))
+
+	defer func(trade func(side, price, amount, category, base, quote string, trace ...string) (string, error), admins []string) {
+		oceanTrade, Admins = trade, admins
+	}(oceanTrade, Admins)
+	oceanTrade = fakeFill(ctx, ant)
+	Admins = []string{"admin"}
+	var alerts []string
+	ant.send = func(ctx context.Context, view bot.MessageView, text string) error {
+		alerts = append(alerts, text)
+		return nil
+	}
+
+	event := &ProfitEvent{ID: UuidWithString("triangle")}
+	ant.settleLeft(ctx, "cycle", 3, event, BTC, USDT, dec("0.01"))
+
+	size, _ := SizeSend(PageSideAsk, dec("9950"), dec("0.01"))
+	unwinds := store.(*memoryStore).unwinds
+	if len(unwinds) != 1 {
+		t.Fatalf("%d unwinds recorded, want 1", len(unwinds))
+	}
+	u := unwinds[0]
+	if u.Asset != USDT || !u.Amount.Equal(size.Receive()) || !u.Cost.Equal(dec("100")) || !u.Loss.Equal(dec("100").Sub(size.Receive())) || !u.Stuck.IsZero() {
+		t.Fatalf("unwind recorded as %+v, returned want %v", u, size.Receive())
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("%d alerts sent, want 1", len(alerts))
+	}
+}
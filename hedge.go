@@ -0,0 +1,318 @@
+package ant
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//ocean.one上的一笔在途订单，需要对冲的每次成交后立即在exin上对冲
+type position struct {
+	event *ProfitEvent
+	//是否在exin上对冲，三角套利的订单不需要
+	otc bool
+	//成交得到的总数量和撤单退回的数量
+	received decimal.Decimal
+	refund   decimal.Decimal
+	//limit单全部成交时得到的数量，收到后不会再有退款，为0时只按退款结束
+	expect decimal.Decimal
+	//成交后得到、尚未在exin上对冲的数量
+	filled  decimal.Decimal
+	hedges  []string
+	hedging bool
+	//exin第一次无法对冲的时间，对冲成功后清零
+	failedAt time.Time
+	//已收到exin返回的对冲单数量
+	returns int
+	//已经发出撤单
+	cancelled bool
+	//挂在ocean.one上的limit单，撤单成功之前到期也不能结束
+	resting bool
+	//已收到撤单退款，不会再有成交
+	done bool
+	//处理已有资金的订单：平仓、强制平仓和三角套利的后续步骤，退出过程中也要执行
+	settlement bool
+	once       sync.Once
+	closed     chan struct{}
+}
+
+func newPosition(event *ProfitEvent, otc bool) *position {
+	return &position{event: event, otc: otc, closed: make(chan struct{})}
+}
+
+func (p *position) finish() {
+	p.done = true
+	p.once.Do(func() { close(p.closed) })
+}
+
+//买单成交得到base，在exin卖出；卖单成交得到quote，在exin买回base
+func (p *position) hedgeSide() (string, string) {
+	if p.event.Category == PageSideBid {
+		return PageSideAsk, p.event.Base
+	}
+	return PageSideBid, p.event.Quote
+}
+
+//exin对send资产的交易限额
+func (p *position) limits() (decimal.Decimal, decimal.Decimal) {
+	event := p.event
+	min, max := event.Min, event.Max
+	if _, send := p.hedgeSide(); send == event.Quote {
+		min, max = min.Mul(event.Price), max.Mul(event.Price)
+	}
+	return min, max
+}
+
+//下单前登记，保证立即成交的snapshot也能匹配上；同一个event已在途，或者正在退出且不是settlement时返回nil
+func (ant *Ant) track(event *ProfitEvent, otc, settlement bool) *position {
+	p := newPosition(event, otc)
+	p.settlement = settlement
+	if !ant.registry.Add(p) {
+		return nil
+	}
+	return p
+}
+
+func (ant *Ant) untrack(p *position) {
+	ant.registry.Remove(p)
+}
+
+func (ant *Ant) cancel(p *position) {
+	if err := OceanCancel(p.event.ExchangeOrder); err != nil {
+		log.Println("cancel error", err)
+		return
+	}
+	ant.registry.Lock()
+	p.cancelled = true
+	ant.registry.Unlock()
+}
+
+//对冲已成交的部分，不足exin最小交易量的攒到下次成交一起对冲，超过最大交易量的拆成多笔
+func (ant *Ant) hedge(ctx context.Context, p *position) {
+	ant.registry.Lock()
+	if !p.otc || p.hedging || !p.filled.IsPositive() {
+		ant.registry.Unlock()
+		return
+	}
+	event := p.event
+	side, _ := p.hedgeSide()
+	min, _ := p.limits()
+	if p.filled.LessThan(min) {
+		ant.registry.Unlock()
+		return
+	}
+	job := &hedgeJob{
+		side:   side,
+		base:   event.Base,
+		quote:  event.Quote,
+		shares: []hedgeShare{{EventId: event.ID, Amount: p.filled}},
+		key:    event.ID + ExinCore,
+		offset: len(p.hedges),
+		pending: func(trace string, shares []hedgeShare) {
+			ant.registry.Lock()
+			ant.registry.addExin(p, trace)
+			ant.registry.Unlock()
+		},
+	}
+	p.hedging = true
+	ant.registry.Unlock()
+
+	traces, hedged, err := ant.executeHedge(ctx, job)
+	if err != nil {
+		log.Println("hedge error", err)
+	}
+
+	ant.registry.Lock()
+	defer ant.registry.Unlock()
+	p.hedging = false
+	if err == nil {
+		p.failedAt = time.Time{}
+	} else if p.failedAt.IsZero() {
+		p.failedAt = time.Now()
+	}
+	//去掉下单失败的trace
+	if len(p.hedges) > job.offset+len(traces) {
+		failed := append([]string{}, p.hedges[job.offset+len(traces):]...)
+		for _, trace := range failed {
+			ant.registry.removeExin(p, trace)
+		}
+	}
+	p.filled = p.filled.Sub(hedged)
+	if len(traces) > 0 {
+		event.OtcOrder = traces[len(traces)-1]
+	}
+}
+
+//订单撤销收到退款且对冲单都已返回后做最后的对账，否则在过期1min后结束，force时立即结束
+func (ant *Ant) reconcile(ctx context.Context, p *position, force bool) {
+	ant.registry.Lock()
+	event := p.event
+	expired := force || event.CreatedAt.Add(time.Duration(event.Expire)).Add(1*time.Minute).Before(time.Now())
+	//撤单失败的挂单仍可能成交，重试撤单，成功后才结束
+	if expired && !force && p.resting && !p.cancelled && !p.done {
+		ant.registry.Unlock()
+		ant.cancel(p)
+		return
+	}
+	if !p.otc || p.hedging || (!expired && (!p.done || p.returns < len(p.hedges))) {
+		ant.registry.Unlock()
+		return
+	}
+	//exin无法对冲的部分等待fallback处理
+	if !force && !p.failedAt.IsZero() && p.filled.IsPositive() {
+		ant.registry.Unlock()
+		return
+	}
+	//不足最小交易量的余数放进余数池
+	if p.filled.IsPositive() {
+		side, _ := p.hedgeSide()
+		ant.addResidual(side, event.Base, event.Quote, p)
+		p.filled = decimal.Zero
+	}
+	ant.registry.remove(p)
+	updates := map[string]interface{}{"base_amount": event.BaseAmount, "quote_amount": event.QuoteAmount, "otc_order": event.OtcOrder}
+	ant.registry.Unlock()
+
+	if err := Storage(ctx).UpdateEvent(event.ID, updates); err != nil {
+		log.Println("update event error", err)
+	}
+}
+
+//需要在exin上对冲的策略，三角套利和强制平仓只在ocean.one上交易
+func hedged(strategy string) bool {
+	return strategy != StrategyTriangle && strategy != StrategyFallback
+}
+
+//重启前下的订单不在registry中，按数据库中的event恢复，之后的成交照常对冲；
+//本次运行中已经结束的event不再恢复，只把金额记到event上
+func (ant *Ant) restore(ctx context.Context, s *Snapshot) (*position, error) {
+	traces := make([]string, 0)
+	for _, id := range []string{s.TraceId, s.AskOrderId, s.BidOrderId, s.OrderId} {
+		if id != "" {
+			traces = append(traces, id)
+		}
+	}
+	var event *ProfitEvent
+	var err error
+	switch s.OpponentId {
+	case OceanCore:
+		event, err = Storage(ctx).FindEventByOrder(traces...)
+	case ExinCore:
+		var orders []HedgeOrder
+		orders, err = Storage(ctx).FindHedgeOrders(traces...)
+		if err == nil && len(orders) > 0 {
+			event, err = Storage(ctx).FindEvent(orders[0].EventId)
+		}
+	}
+	if err != nil || event == nil {
+		return nil, err
+	}
+
+	ant.registry.Lock()
+	//已经由其他snapshot恢复，只补上这笔对冲单
+	if p, ok := ant.registry.events[event.ID]; ok {
+		if s.OpponentId == ExinCore {
+			ant.registry.addExin(p, s.TraceId)
+		}
+		ant.registry.Unlock()
+		return p, nil
+	}
+	p := newPosition(event, hedged(event.Strategy))
+	//处理已有资金，退出过程中也要恢复
+	p.settlement = true
+	if s.OpponentId == ExinCore {
+		p.hedges = append(p.hedges, s.TraceId)
+	}
+	added := ant.registry.add(p)
+	ant.registry.Unlock()
+	if added {
+		log.Printf("restored %s %s from snapshot %s", event.Strategy, event.ID, s.SnapshotId)
+		return p, nil
+	}
+
+	amount, _ := decimal.NewFromString(s.Amount)
+	updates := make(map[string]interface{}, 0)
+	if s.AssetId == event.Base {
+		updates["base_amount"] = event.BaseAmount.Add(amount)
+	} else if s.AssetId == event.Quote {
+		updates["quote_amount"] = event.QuoteAmount.Add(amount)
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+	return nil, Storage(ctx).UpdateEvent(event.ID, updates)
+}
+
+//ocean.one订单及其exin对冲单的snapshot，需要对冲的成交立即对冲，snapshot需要先Decode
+func (ant *Ant) HandleSnapshot(ctx context.Context, s *Snapshot) error {
+	if s.OpponentId != OceanCore && s.OpponentId != ExinCore {
+		return nil
+	}
+
+	ant.registry.Lock()
+	var p *position
+	var ok bool
+	switch s.OpponentId {
+	case OceanCore:
+		p, ok = ant.registry.byOcean(s.TraceId, s.AskOrderId, s.BidOrderId, s.OrderId)
+	case ExinCore:
+		p, ok = ant.registry.byExin(s.TraceId, s.OrderId)
+		if trace, shares, found := ant.registry.byResidual(s.TraceId, s.OrderId); !ok && found {
+			ant.registry.Unlock()
+			return ant.settleResidual(ctx, s, trace, shares)
+		}
+	}
+	ant.registry.Unlock()
+	if !ok {
+		restored, err := ant.restore(ctx, s)
+		if err != nil || restored == nil {
+			return err
+		}
+		p = restored
+	}
+
+	ant.registry.Lock()
+	amount, _ := decimal.NewFromString(s.Amount)
+	event := p.event
+	if s.AssetId == event.Base {
+		event.BaseAmount = event.BaseAmount.Add(amount)
+	} else if s.AssetId == event.Quote {
+		event.QuoteAmount = event.QuoteAmount.Add(amount)
+	}
+
+	filled := false
+	if s.OpponentId == ExinCore && amount.IsPositive() {
+		p.returns += 1
+	}
+	if s.OpponentId == OceanCore && amount.IsPositive() {
+		if _, to := p.hedgeSide(); s.AssetId == to {
+			p.received = p.received.Add(amount)
+			if p.otc && s.ReplyType == ReplyMatch {
+				p.filled = p.filled.Add(amount)
+				filled = true
+			}
+			if p.expect.IsPositive() && p.received.GreaterThanOrEqual(p.expect) {
+				p.finish()
+			}
+		} else {
+			p.refund = p.refund.Add(amount)
+		}
+		switch s.ReplyType {
+		case ReplyCancel, ReplyRefund, ReplyError:
+			p.finish()
+		}
+	}
+	done := p.done
+	ant.registry.Unlock()
+
+	if filled || done {
+		ant.hedge(ctx, p)
+	}
+	if done {
+		ant.reconcile(ctx, p, false)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package ant
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	VenueOcean = "ocean"
+	VenueExin  = "exin"
+)
+
+//盈亏折算的参考货币
+var ReferenceAsset = USDT
+
+//一笔匹配到ProfitEvent的资金变动，exin的对冲单按HedgeOrder分摊到各个event；
+//exin返回的数量已经扣除手续费，Fee只用于统计
+type LedgerEntry struct {
+	ID         string          `json:"id"               gorm:"type:varchar(36);primary_key"`
+	EventId    string          `json:"event_id"         gorm:"type:varchar(36);index"`
+	SnapshotId string          `json:"snapshot_id"      gorm:"type:varchar(36);index"`
+	Venue      string          `json:"venue"            gorm:"type:varchar(8)"`
+	AssetId    string          `json:"asset_id"         gorm:"type:varchar(36)"`
+	Amount     decimal.Decimal `json:"amount"           gorm:"type:varchar(36)"`
+	Fee        decimal.Decimal `json:"fee"              gorm:"type:varchar(36)"`
+	FeeAsset   string          `json:"fee_asset"        gorm:"type:varchar(36)"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func (LedgerEntry) TableName() string {
+	return "ant_ledger_entries"
+}
+
+//每个ProfitEvent的已实现盈亏，剩余的base按下单价格折算成quote，Reference和Fee以参考货币计
+type EventPnL struct {
+	EventId     string          `json:"event_id"         gorm:"type:varchar(36);primary_key"`
+	Strategy    string          `json:"strategy"         gorm:"type:varchar(16)"`
+	Base        string          `json:"base"             gorm:"type:varchar(36)"`
+	Quote       string          `json:"quote"            gorm:"type:varchar(36)"`
+	Day         string          `json:"day"              gorm:"type:varchar(10);index"`
+	BaseAmount  decimal.Decimal `json:"base_amount"      gorm:"type:varchar(36)"`
+	QuoteAmount decimal.Decimal `json:"quote_amount"     gorm:"type:varchar(36)"`
+	Fee         decimal.Decimal `json:"fee"              gorm:"type:varchar(36)"`
+	PnL         decimal.Decimal `json:"pnl"              gorm:"type:varchar(36)"`
+	Reference   decimal.Decimal `json:"reference"        gorm:"type:varchar(36)"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func (EventPnL) TableName() string {
+	return "ant_event_pnls"
+}
+
+//把ocean.one和exin的snapshot记入账本，并更新相关event的盈亏
+func (ant *Ant) RecordLedger(ctx context.Context, s *Snapshot) error {
+	amount, err := decimal.NewFromString(s.Amount)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]LedgerEntry, 0)
+	newEntry := func(eventId, venue string, amount, fee decimal.Decimal, feeAsset string) LedgerEntry {
+		return LedgerEntry{
+			ID:         UuidWithString(s.SnapshotId + eventId),
+			EventId:    eventId,
+			SnapshotId: s.SnapshotId,
+			Venue:      venue,
+			AssetId:    s.AssetId,
+			Amount:     amount,
+			Fee:        fee,
+			FeeAsset:   feeAsset,
+			CreatedAt:  s.CreatedAt,
+		}
+	}
+
+	switch s.OpponentId {
+	case OceanCore:
+		traces := []string{s.TraceId}
+		for _, id := range []string{s.AskOrderId, s.BidOrderId, s.OrderId} {
+			if id != "" {
+				traces = append(traces, id)
+			}
+		}
+		ant.registry.Lock()
+		p, ok := ant.registry.byOcean(traces...)
+		ant.registry.Unlock()
+		if ok {
+			entries = append(entries, newEntry(p.event.ID, VenueOcean, amount, decimal.Zero, ""))
+			break
+		}
+		event, err := Storage(ctx).FindEventByOrder(traces...)
+		if err != nil || event == nil {
+			return err
+		}
+		entries = append(entries, newEntry(event.ID, VenueOcean, amount, decimal.Zero, ""))
+	case ExinCore:
+		traces := []string{s.TraceId}
+		if s.OrderId != "" {
+			traces = append(traces, s.OrderId)
+		}
+		fee, feeAsset := decimal.Zero, s.FeeAsset
+		if s.Fee != "" {
+			fee, _ = decimal.NewFromString(s.Fee)
+		}
+		orders, err := Storage(ctx).FindHedgeOrders(traces...)
+		if err != nil {
+			return err
+		}
+		total := decimal.Zero
+		for _, order := range orders {
+			total = total.Add(order.Amount)
+		}
+		if total.IsPositive() {
+			for _, order := range orders {
+				ratio := order.Amount.Div(total)
+				entries = append(entries, newEntry(order.EventId, VenueExin, amount.Mul(ratio), fee.Mul(ratio), feeAsset))
+			}
+			break
+		}
+		//对冲单还没写入数据库时从在途订单中查找
+		ant.registry.Lock()
+		p, ok := ant.registry.byExin(traces...)
+		ant.registry.Unlock()
+		if !ok {
+			return nil
+		}
+		entries = append(entries, newEntry(p.event.ID, VenueExin, amount, fee, feeAsset))
+	default:
+		return nil
+	}
+
+	for _, entry := range entries {
+		if err := Storage(ctx).SaveLedgerEntry(&entry); err != nil {
+			return err
+		}
+		if err := ant.updatePnL(ctx, entry.EventId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//按账本重新计算event的盈亏
+func (ant *Ant) updatePnL(ctx context.Context, id string) error {
+	event, err := Storage(ctx).FindEvent(id)
+	if err != nil || event == nil {
+		return err
+	}
+	entries, err := Storage(ctx).LedgerEntries(id)
+	if err != nil {
+		return err
+	}
+
+	pnl := EventPnL{
+		EventId:     event.ID,
+		Strategy:    event.Strategy,
+		Base:        event.Base,
+		Quote:       event.Quote,
+		Day:         event.CreatedAt.UTC().Format("2006-01-02"),
+		BaseAmount:  decimal.Zero,
+		QuoteAmount: decimal.Zero,
+		Fee:         decimal.Zero,
+		UpdatedAt:   time.Now(),
+	}
+	for _, entry := range entries {
+		if entry.AssetId == event.Base {
+			pnl.BaseAmount = pnl.BaseAmount.Add(entry.Amount)
+		} else if entry.AssetId == event.Quote {
+			pnl.QuoteAmount = pnl.QuoteAmount.Add(entry.Amount)
+		}
+		if entry.FeeAsset == event.Base {
+			pnl.Fee = pnl.Fee.Add(entry.Fee.Mul(event.Price))
+		} else if entry.FeeAsset == event.Quote {
+			pnl.Fee = pnl.Fee.Add(entry.Fee)
+		}
+	}
+	rate, err := ant.oracle.Price(ctx, event.Quote, ReferenceAsset)
+	if err != nil {
+		log.Println("reference price error", err)
+	}
+	pnl.PnL = pnl.QuoteAmount.Add(pnl.BaseAmount.Mul(event.Price))
+	pnl.Reference = pnl.PnL.Mul(rate)
+	pnl.Fee = pnl.Fee.Mul(rate)
+	return Storage(ctx).SaveEventPnL(&pnl)
+}
+
+//按天、交易对、策略汇总的盈亏
+type PnLSummary struct {
+	Day       string
+	Pair      string
+	Strategy  string
+	Events    int
+	Fee       decimal.Decimal
+	Reference decimal.Decimal
+}
+
+//查询[from, to)之间的盈亏，by可以是day、pair、strategy的任意组合，为空时只汇总总数
+func QueryPnL(ctx context.Context, from, to time.Time, by ...string) ([]*PnLSummary, error) {
+	pnls, err := Storage(ctx).EventPnLs(from.UTC().Format("2006-01-02"), to.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*PnLSummary, 0)
+	keys := make([]string, 0)
+	for _, pnl := range pnls {
+		summary := PnLSummary{}
+		for _, field := range by {
+			switch field {
+			case "day":
+				summary.Day = pnl.Day
+			case "pair":
+				summary.Pair = Who(pnl.Base) + "/" + Who(pnl.Quote)
+			case "strategy":
+				summary.Strategy = pnl.Strategy
+			}
+		}
+		key := strings.Join([]string{summary.Day, summary.Pair, summary.Strategy}, "|")
+		group, ok := groups[key]
+		if !ok {
+			group = &summary
+			group.Fee, group.Reference = decimal.Zero, decimal.Zero
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.Events += 1
+		group.Fee = group.Fee.Add(pnl.Fee)
+		group.Reference = group.Reference.Add(pnl.Reference)
+	}
+
+	sort.Strings(keys)
+	summaries := make([]*PnLSummary, 0, len(keys))
+	for _, key := range keys {
+		summaries = append(summaries, groups[key])
+	}
+	return summaries, nil
+}
@@ -0,0 +1,227 @@
+package ant
+
+import (
+	"context"
+	"log"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+//每边挂单的数量
+var MakerAmount = map[string]float64{
+	BTC: 0.002,
+	EOS: 2,
+	ETH: 0.05,
+	XIN: 0.05,
+}
+
+type MakerConfig struct {
+	//相对exin价格的价差
+	Spread float64
+	//每边挂单数量
+	Amount float64
+	//目标库存，超出时压低报价多卖少买
+	Target float64
+	//库存偏移系数
+	Skew float64
+	//价格变化超过该比例时重新挂单
+	Reprice float64
+	//挂单最长存活时间
+	Lifetime time.Duration
+}
+
+func NewMakerConfig(base string) MakerConfig {
+	return MakerConfig{
+		Spread:   0.008,
+		Amount:   MakerAmount[base],
+		Target:   Wallet[base],
+		Skew:     0.5,
+		Reprice:  0.002,
+		Lifetime: 60 * time.Second,
+	}
+}
+
+//根据exin价格和当前库存计算两边的挂单，不与ocean.one上的对手盘交叉
+func (config MakerConfig) Targets(otc, exchange *Depth, inventory decimal.Decimal) map[string]Order {
+	targets := make(map[string]Order, 0)
+	if config.Amount <= 0 {
+		return targets
+	}
+
+	one := decimal.NewFromFloat(1.0)
+	spread := decimal.NewFromFloat(config.Spread)
+	amount := decimal.NewFromFloat(config.Amount)
+	half := spread.Div(decimal.NewFromFloat(2.0))
+	skew := inventory.Sub(decimal.NewFromFloat(config.Target)).Div(amount).Mul(half).Mul(decimal.NewFromFloat(config.Skew))
+	if skew.GreaterThan(half) {
+		skew = half
+	} else if skew.LessThan(half.Neg()) {
+		skew = half.Neg()
+	}
+
+	if len(otc.Bids) > 0 {
+		price := otc.Bids[0].Price.Mul(one.Sub(spread).Sub(skew)).Truncate(PricePrecision)
+		if len(exchange.Asks) == 0 || price.LessThan(exchange.Asks[0].Price) {
+			targets[PageSideBid] = Order{Price: price, Amount: amount, Min: otc.Bids[0].Min, Max: otc.Bids[0].Max}
+		}
+	}
+	if len(otc.Asks) > 0 {
+		price := otc.Asks[0].Price.Mul(one.Add(spread).Sub(skew)).Truncate(PricePrecision)
+		if len(exchange.Bids) == 0 || price.GreaterThan(exchange.Bids[0].Price) {
+			targets[PageSideAsk] = Order{Price: price, Amount: amount, Min: otc.Asks[0].Min, Max: otc.Asks[0].Max}
+		}
+	}
+	return targets
+}
+
+func (config MakerConfig) stale(event *ProfitEvent, target Order) bool {
+	if event.CreatedAt.Add(config.Lifetime).Before(time.Now()) {
+		return true
+	}
+	diff := target.Price.Sub(event.Price).Div(event.Price).Abs()
+	return diff.GreaterThan(decimal.NewFromFloat(config.Reprice))
+}
+
+//在ocean.one上双边挂单做市，成交后立即在exin上对冲
+func (ant *Ant) Making(ctx context.Context, base, quote string, config MakerConfig) {
+	pair := base + "-" + quote
+	resting := make(map[string]*ProfitEvent, 0)
+	defer func() {
+		for _, event := range resting {
+			ant.cancelQuote(event)
+		}
+	}()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			otc, err := GetExinDepth(ctx, base, quote)
+			if err != nil || !ant.tradable(base, quote) || ant.pairPaused(base, quote) {
+				//exin不可用时无法对冲，余额对不上或管理员暂停时，撤掉所有挂单
+				for side, event := range resting {
+					if ant.cancelQuote(event) {
+						delete(resting, side)
+					}
+				}
+				continue
+			}
+
+			ant.assetsLock.Lock()
+			inventory := ant.assets[base]
+			baseBalance, quoteBalance := ant.assets[base], ant.assets[quote]
+			ant.assetsLock.Unlock()
+
+			targets := config.Targets(otc, ant.books[pair].GetDepth(1), inventory)
+			for _, side := range []string{PageSideBid, PageSideAsk} {
+				target, ok := targets[side]
+				if event := resting[side]; event != nil {
+					if ok && !config.stale(event, target) {
+						continue
+					}
+					//撤单失败时保留，下个tick重试，确认前这一边不挂新单
+					if !ant.cancelQuote(event) {
+						continue
+					}
+					delete(resting, side)
+				}
+				if !ok {
+					continue
+				}
+
+				if ant.enableOcean {
+					if side == PageSideBid && target.Amount.Mul(target.Price).GreaterThan(quoteBalance) {
+						continue
+					}
+					if side == PageSideAsk && target.Amount.GreaterThan(baseBalance) {
+						continue
+					}
+				}
+				if event := ant.placeQuote(ctx, base, quote, side, target, config); event != nil {
+					resting[side] = event
+				}
+			}
+		}
+	}
+}
+
+func (ant *Ant) placeQuote(ctx context.Context, base, quote, side string, target Order, config MakerConfig) *ProfitEvent {
+	id := uuid.Must(uuid.NewV4()).String()
+	event := &ProfitEvent{
+		ID:            id,
+		Category:      side,
+		Strategy:      StrategyMaker,
+		Price:         target.Price,
+		Amount:        target.Amount,
+		Min:           target.Min,
+		Max:           target.Max,
+		Profit:        decimal.NewFromFloat(config.Spread),
+		Base:          base,
+		Quote:         quote,
+		Expire:        int64(config.Lifetime),
+		CreatedAt:     time.Now(),
+		BaseAmount:    decimal.Zero,
+		QuoteAmount:   decimal.Zero,
+		ExchangeOrder: UuidWithString(id + OceanCore),
+	}
+
+	if !ant.enableOcean {
+		log.Printf("maker %s %v@%v, %s/%s", side, event.Amount, event.Price, Who(base), Who(quote))
+		return event
+	}
+
+	ant.assetsLock.Lock()
+	balance := ant.assets[base]
+	if side == PageSideBid {
+		balance = ant.assets[quote]
+	}
+	ant.assetsLock.Unlock()
+	size, err := SizeOrder(side, event.Price, event.Amount, balance)
+	if err != nil {
+		log.Println("maker size error", err)
+		return nil
+	}
+	event.Price = size.Price
+
+	p := ant.track(event, true, false)
+	if p == nil {
+		return nil
+	}
+	ant.registry.Lock()
+	p.resting = true
+	ant.registry.Unlock()
+	if _, err := oceanTrade(side, size.Price.String(), size.Send.String(), OrderTypeLimit, base, quote, event.ExchangeOrder); err != nil {
+		log.Println("maker trade error", err)
+		ant.untrack(p)
+		return nil
+	}
+	if err := Storage(ctx).SaveEvent(event); err != nil {
+		log.Println("create quote error", err)
+	}
+	return event
+}
+
+//撤单后仍保留在registry中，直到收到退款并对冲完毕；返回false表示撤单没有成功，挂单可能还在
+func (ant *Ant) cancelQuote(event *ProfitEvent) bool {
+	if !ant.enableOcean {
+		return true
+	}
+	p, ok := ant.registry.Event(event.ID)
+	if !ok {
+		return true
+	}
+	ant.registry.Lock()
+	finished := p.cancelled || p.done
+	ant.registry.Unlock()
+	if !finished {
+		ant.cancel(p)
+	}
+	ant.registry.Lock()
+	defer ant.registry.Unlock()
+	return p.cancelled || p.done
+}
@@ -0,0 +1,46 @@
+package ant
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//撤单失败的挂单还可能成交，要留在registry中直到撤单成功
+func TestCancelQuoteFails(t *testing.T) {
+	ctx := SetStore(context.Background(), NewMemoryStore())
+	ant := NewAnt(true, false)
+	event := &ProfitEvent{
+		ID:            UuidWithString("quote"),
+		Category:      PageSideBid,
+		Strategy:      StrategyMaker,
+		Price:         dec("10000"),
+		Base:          BTC,
+		Quote:         USDT,
+		Expire:        int64(time.Second),
+		CreatedAt:     time.Now().Add(-time.Hour),
+		ExchangeOrder: UuidWithString("quote" + OceanCore),
+	}
+	p := ant.track(event, true, false)
+	p.resting = true
+
+	//测试中OceanCancel总是失败
+	if ant.cancelQuote(event) {
+		t.Fatal("failed cancel reported as done")
+	}
+	ant.reconcile(ctx, p, false)
+	if _, ok := ant.registry.Event(event.ID); !ok {
+		t.Fatal("expired quote dropped before the cancel is confirmed")
+	}
+
+	ant.registry.Lock()
+	p.cancelled = true
+	ant.registry.Unlock()
+	if !ant.cancelQuote(event) {
+		t.Fatal("confirmed cancel reported as failed")
+	}
+	ant.reconcile(ctx, p, false)
+	if _, ok := ant.registry.Event(event.ID); ok {
+		t.Fatal("cancelled quote kept after it expired")
+	}
+}
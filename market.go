@@ -0,0 +1,218 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//聊天查询深度时最多返回的档数
+const MarketMaxDepth = 20
+
+//Watching拿到的最新exin报价，聊天查询时优先使用
+type exinQuotes struct {
+	sync.Mutex
+	depths map[string]*Depth
+}
+
+func (q *exinQuotes) set(pair string, depth *Depth) {
+	q.Lock()
+	defer q.Unlock()
+	q.depths[pair] = depth
+}
+
+func (q *exinQuotes) get(pair string) *Depth {
+	q.Lock()
+	defer q.Unlock()
+	return q.depths[pair]
+}
+
+//exin报价的获取时间
+func quotedAt(depth *Depth) time.Time {
+	if depth == nil {
+		return time.Time{}
+	}
+	for _, orders := range [][]Order{depth.Asks, depth.Bids} {
+		if len(orders) > 0 {
+			return orders[0].UpdatedAt
+		}
+	}
+	return time.Time{}
+}
+
+//解析 BTC/USDT 或 BTC-USDT，返回asset id
+func ParsePair(pair string) (string, string, error) {
+	symbols := strings.FieldsFunc(strings.ToUpper(pair), func(r rune) bool { return r == '/' || r == '-' })
+	if len(symbols) != 2 {
+		return "", "", fmt.Errorf("invalid pair %s, use BTC/USDT", pair)
+	}
+	base, quote := GetAssetId(symbols[0]), GetAssetId(symbols[1])
+	if base == "" {
+		return "", "", fmt.Errorf("unknown symbol %s", symbols[0])
+	}
+	if quote == "" {
+		return "", "", fmt.Errorf("unknown symbol %s", symbols[1])
+	}
+	return base, quote, nil
+}
+
+//一个交易对两边的行情
+type MarketView struct {
+	Base     string
+	Quote    string
+	Ocean    *Depth
+	BookAge  time.Duration
+	Synced   bool
+	Exin     *Depth
+	QuoteAge time.Duration
+	//行情过期时Inspect跳过的原因
+	Stale     string
+	Threshold decimal.Decimal
+}
+
+//live为false时只用缓存的exin报价，不发请求
+func (ant *Ant) Market(ctx context.Context, base, quote string, limit int, live bool) (*MarketView, error) {
+	pair := base + "-" + quote
+	book, ok := ant.books[pair]
+	if !ok {
+		return nil, fmt.Errorf("%s/%s is not watched", Who(base), Who(quote))
+	}
+	now := time.Now()
+	updatedAt, _, synced := book.Clock()
+	m := &MarketView{
+		Base:      base,
+		Quote:     quote,
+		Ocean:     book.GetDepth(limit),
+		BookAge:   now.Sub(updatedAt),
+		Synced:    synced,
+		Exin:      ant.quotes.get(pair),
+		Stale:     ant.StaleReason(base, quote),
+		Threshold: ant.threshold(),
+	}
+	if live && (m.Exin == nil || now.Sub(quotedAt(m.Exin)) > MaxDataAge.QuoteAge) {
+		//请求失败时仍然返回缓存的报价，由QuoteAge体现
+		if depth, err := GetExinDepth(ctx, base, quote); err == nil {
+			ant.quotes.set(pair, depth)
+			m.Exin = depth
+		}
+	}
+	if m.Exin != nil {
+		m.QuoteAge = time.Now().Sub(quotedAt(m.Exin))
+	}
+	return m, nil
+}
+
+//所有正在监控的交易对，按名字排序
+func (ant *Ant) watchedPairs() [][2]string {
+	pairs := make([][2]string, 0, len(ant.books))
+	for _, book := range ant.books {
+		pairs = append(pairs, [2]string{book.base, book.quote})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return Who(pairs[i][0])+"/"+Who(pairs[i][1]) < Who(pairs[j][0])+"/"+Who(pairs[j][1])
+	})
+	return pairs
+}
+
+func (m *MarketView) pair() string {
+	return Who(m.Base) + "/" + Who(m.Quote)
+}
+
+func (m *MarketView) ages() string {
+	ocean := fmt.Sprintf("ocean %v old", m.BookAge.Round(time.Second))
+	if !m.Synced {
+		ocean = "ocean not synced"
+	}
+	exin := "no exin quote"
+	if m.Exin != nil {
+		exin = fmt.Sprintf("exin %v old", m.QuoteAge.Round(time.Millisecond))
+	}
+	return ocean + ", " + exin
+}
+
+func topPrice(orders []Order) string {
+	if len(orders) == 0 {
+		return "-"
+	}
+	return orders[0].Price.String()
+}
+
+//两边的买一和卖一
+func (m *MarketView) PriceText() string {
+	lines := []string{m.pair()}
+	lines = append(lines, fmt.Sprintf("Mixcoin  bid %s  ask %s", topPrice(m.Ocean.Bids), topPrice(m.Ocean.Asks)))
+	if m.Exin != nil {
+		lines = append(lines, fmt.Sprintf("ExinOne  bid %s  ask %s", topPrice(m.Exin.Bids), topPrice(m.Exin.Asks)))
+	}
+	lines = append(lines, m.ages())
+	return strings.Join(lines, "\n")
+}
+
+//ocean.one的深度和exin的报价区间
+func (m *MarketView) DepthText() string {
+	lines := []string{m.pair(), "Mixcoin asks"}
+	for i := len(m.Ocean.Asks) - 1; i >= 0; i-- {
+		lines = append(lines, fmt.Sprintf("  %-14s %s", m.Ocean.Asks[i].Price.String(), m.Ocean.Asks[i].Amount.String()))
+	}
+	lines = append(lines, "Mixcoin bids")
+	for _, order := range m.Ocean.Bids {
+		lines = append(lines, fmt.Sprintf("  %-14s %s", order.Price.String(), order.Amount.String()))
+	}
+	if m.Exin != nil {
+		for _, side := range []struct {
+			name   string
+			orders []Order
+		}{{"ask", m.Exin.Asks}, {"bid", m.Exin.Bids}} {
+			if len(side.orders) == 0 {
+				continue
+			}
+			order := side.orders[0]
+			lines = append(lines, fmt.Sprintf("ExinOne %s %s, %s - %s %s", side.name, order.Price.String(), order.Min.Round(8).String(), order.Max.Round(8).String(), Who(m.Base)))
+		}
+	}
+	lines = append(lines, m.ages())
+	return strings.Join(lines, "\n")
+}
+
+//和Inspect的算法一致，两个方向的价差与当前的阈值比较
+func (m *MarketView) SpreadText() string {
+	percent := decimal.NewFromFloat(100)
+	threshold := m.Threshold
+	lines := []string{m.pair()}
+	if m.Exin == nil {
+		return strings.Join(append(lines, m.ages()), "\n")
+	}
+	spreads := []struct {
+		action string
+		ocean  []Order
+		exin   []Order
+	}{
+		{"Sell in Mixcoin", m.Ocean.Bids, m.Exin.Asks},
+		{" Buy in Mixcoin", m.Ocean.Asks, m.Exin.Bids},
+	}
+	for i, s := range spreads {
+		if len(s.ocean) == 0 || len(s.exin) == 0 {
+			lines = append(lines, s.action+": -")
+			continue
+		}
+		spread := s.ocean[0].Price.Sub(s.exin[0].Price).Div(s.exin[0].Price)
+		if i == 1 {
+			spread = spread.Mul(decimal.NewFromFloat(-1.0))
+		}
+		mark := ""
+		if !spread.LessThan(threshold) {
+			mark = " *"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s%%%s", s.action, spread.Mul(percent).Round(2).String(), mark))
+	}
+	lines = append(lines, fmt.Sprintf("threshold %s%%, %s", threshold.Mul(percent).Round(2).String(), m.ages()))
+	if m.Stale != "" {
+		lines = append(lines, "skipped: "+m.Stale)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,114 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+//snapshot memo解码后的类型
+const (
+	//我们发出的下单转账
+	ReplyOrder  = "order"
+	ReplyMatch  = "match"
+	ReplyCancel = "cancel"
+	ReplyRefund = "refund"
+	ReplyReturn = "return"
+	ReplyError  = "error"
+)
+
+var oceanReplyTypes = map[string]string{
+	OceanSourceTradeConfirmed: ReplyMatch,
+	OceanSourceOrderCancelled: ReplyCancel,
+	OceanSourceOrderFilled:    ReplyRefund,
+	OceanSourceOrderInvalid:   ReplyError,
+}
+
+var exinReplyTypes = map[string]string{
+	"R": ReplyReturn,
+	"F": ReplyRefund,
+	"E": ReplyError,
+}
+
+func orderId(id uuid.UUID) string {
+	if uuid.Equal(id, uuid.Nil) {
+		return ""
+	}
+	return id.String()
+}
+
+//snapshot的对手方对应的交易所
+func venueOf(opponent string) string {
+	switch opponent {
+	case OceanCore:
+		return VenueOcean
+	case ExinCore:
+		return VenueExin
+	}
+	return ""
+}
+
+//按对手方解码memo，填入Venue、ReplyType、相关订单、价格和手续费
+func (s *Snapshot) Decode() error {
+	s.Venue = venueOf(s.OpponentId)
+	if s.Venue == "" {
+		return nil
+	}
+	amount, err := decimal.NewFromString(s.Amount)
+	if err != nil {
+		return err
+	}
+	if amount.IsNegative() {
+		s.ReplyType, s.OrderId = ReplyOrder, s.TraceId
+		if s.Venue == VenueOcean {
+			var order OceanOrder
+			if err := order.Unpack(s.Data); err != nil {
+				return err
+			}
+			s.Price = order.P
+		}
+		return nil
+	}
+
+	switch s.Venue {
+	case VenueOcean:
+		var reply OceanReply
+		if err := reply.Unpack(s.Data); err != nil {
+			return err
+		}
+		replyType, ok := oceanReplyTypes[reply.S]
+		if !ok {
+			return fmt.Errorf("unknown ocean reply %s", reply.S)
+		}
+		s.ReplyType = replyType
+		s.OrderId = orderId(reply.O)
+		s.AskOrderId = orderId(reply.A)
+		s.BidOrderId = orderId(reply.B)
+	case VenueExin:
+		var reply ExinReply
+		if err := reply.Unpack(s.Data); err != nil {
+			return err
+		}
+		replyType, ok := exinReplyTypes[reply.T]
+		if !ok {
+			return fmt.Errorf("unknown exin reply %s", reply.T)
+		}
+		s.ReplyType = replyType
+		s.OrderId = orderId(reply.O)
+		s.Price, s.Fee, s.FeeAsset = reply.P, reply.F, reply.FA
+	}
+	return nil
+}
+
+//ocean.one上某个订单的所有成交
+func FillsOfOrder(ctx context.Context, order string) ([]Snapshot, error) {
+	db := Database(ctx)
+	if db == nil {
+		return nil, fmt.Errorf("fills need a sql store")
+	}
+	var snapshots []Snapshot
+	err := db.Where("reply_type=? AND (ask_order_id=? OR bid_order_id=?)", ReplyMatch, order, order).Order("created_at").Find(&snapshots).Error
+	return snapshots, err
+}
@@ -0,0 +1,286 @@
+package ant
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+)
+
+//内存存储，退出后数据丢失，用于本地调试
+type memoryStore struct {
+	mutex       sync.Mutex
+	snapshots   map[string]Snapshot
+	events      map[string]*ProfitEvent
+	subscribers map[string]Subscriber
+	checkpoints map[string]string
+
+	hedgeOrders   map[string]HedgeOrder
+	ledger        map[string]LedgerEntry
+	pnls          map[string]EventPnL
+	unwinds       []Unwind
+	valuations    []Valuation
+	spreads       []SpreadSample
+	auditLogs     []AuditLog
+	discrepancies []BalanceDiscrepancy
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		snapshots:   make(map[string]Snapshot, 0),
+		events:      make(map[string]*ProfitEvent, 0),
+		subscribers: make(map[string]Subscriber, 0),
+		checkpoints: make(map[string]string, 0),
+		hedgeOrders: make(map[string]HedgeOrder, 0),
+		ledger:      make(map[string]LedgerEntry, 0),
+		pnls:        make(map[string]EventPnL, 0),
+	}
+}
+
+func (m *memoryStore) SaveSnapshot(s *Snapshot) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.snapshots[s.SnapshotId]; !ok {
+		m.snapshots[s.SnapshotId] = *s
+	}
+	return nil
+}
+
+func (m *memoryStore) SnapshotExists(id string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.snapshots[id]
+	return ok, nil
+}
+
+func (m *memoryStore) SaveEvent(e *ProfitEvent) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.events[e.ID]; !ok {
+		event := *e
+		m.events[e.ID] = &event
+	}
+	return nil
+}
+
+//只支持ProfitEvent上会被更新的字段
+func (m *memoryStore) UpdateEvent(id string, updates map[string]interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	event, ok := m.events[id]
+	if !ok {
+		return nil
+	}
+	for column, value := range updates {
+		switch column {
+		case "base_amount":
+			event.BaseAmount = value.(decimal.Decimal)
+		case "quote_amount":
+			event.QuoteAmount = value.(decimal.Decimal)
+		case "otc_order":
+			event.OtcOrder = value.(string)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) FindEvent(id string) (*ProfitEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if event, ok := m.events[id]; ok {
+		e := *event
+		return &e, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) FindEventByOrder(traces ...string) (*ProfitEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, event := range m.events {
+		for _, trace := range traces {
+			if event.ExchangeOrder == trace {
+				e := *event
+				return &e, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) Subscribe(user string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.subscribers[user]; !ok {
+		m.subscribers[user] = *NewSubscriber(user)
+	}
+	return nil
+}
+
+func (m *memoryStore) Unsubscribe(user string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.subscribers, user)
+	return nil
+}
+
+func (m *memoryStore) Subscribers() ([]*Subscriber, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	subscribers := make([]*Subscriber, 0, len(m.subscribers))
+	for _, subscriber := range m.subscribers {
+		s := subscriber
+		subscribers = append(subscribers, &s)
+	}
+	return subscribers, nil
+}
+
+func (m *memoryStore) FindSubscriber(user string) (*Subscriber, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if subscriber, ok := m.subscribers[user]; ok {
+		return &subscriber, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) SaveSubscriber(s *Subscriber) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.subscribers[s.UserId] = *s
+	return nil
+}
+
+func (m *memoryStore) ReadCheckpoint(key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.checkpoints[key], nil
+}
+
+func (m *memoryStore) WriteCheckpoint(key, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.checkpoints[key] = value
+	return nil
+}
+
+func (m *memoryStore) SaveHedgeOrder(o *HedgeOrder) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.hedgeOrders[o.ID]; !ok {
+		m.hedgeOrders[o.ID] = *o
+	}
+	return nil
+}
+
+func (m *memoryStore) FindHedgeOrders(traces ...string) ([]HedgeOrder, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	orders := make([]HedgeOrder, 0)
+	for _, order := range m.hedgeOrders {
+		for _, trace := range traces {
+			if order.TraceId == trace {
+				orders = append(orders, order)
+				break
+			}
+		}
+	}
+	return orders, nil
+}
+
+func (m *memoryStore) SaveLedgerEntry(e *LedgerEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.ledger[e.ID]; !ok {
+		m.ledger[e.ID] = *e
+	}
+	return nil
+}
+
+func (m *memoryStore) LedgerEntries(event string) ([]LedgerEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entries := make([]LedgerEntry, 0)
+	for _, entry := range m.ledger {
+		if entry.EventId == event {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *memoryStore) SaveEventPnL(p *EventPnL) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pnls[p.EventId] = *p
+	return nil
+}
+
+func (m *memoryStore) EventPnLs(from, to string) ([]EventPnL, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	pnls := make([]EventPnL, 0)
+	for _, pnl := range m.pnls {
+		if pnl.Day >= from && pnl.Day < to {
+			pnls = append(pnls, pnl)
+		}
+	}
+	return pnls, nil
+}
+
+func (m *memoryStore) SaveUnwind(u *Unwind) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.unwinds = append(m.unwinds, *u)
+	return nil
+}
+
+func (m *memoryStore) SaveValuation(v *Valuation) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.valuations = append(m.valuations, *v)
+	return nil
+}
+
+func (m *memoryStore) SaveSpread(s *SpreadSample) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.spreads = append(m.spreads, *s)
+	return nil
+}
+
+func (m *memoryStore) PruneSpreads(resolution string, before time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	kept := m.spreads[:0]
+	for _, s := range m.spreads {
+		if s.Resolution != resolution || !s.CreatedAt.Before(before) {
+			kept = append(kept, s)
+		}
+	}
+	m.spreads = kept
+	return nil
+}
+
+func (m *memoryStore) SaveAuditLog(l *AuditLog) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.auditLogs = append(m.auditLogs, *l)
+	return nil
+}
+
+func (m *memoryStore) SaveDiscrepancy(d *BalanceDiscrepancy) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.discrepancies = append(m.discrepancies, *d)
+	return nil
+}
+
+func (m *memoryStore) DB() *gorm.DB {
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
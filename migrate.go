@@ -0,0 +1,604 @@
+package ant
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//一次schema变更，Up和Down是依次执行的sql，写死在这里，不随结构体变化；
+//Apply在Up之后、Revert在Down之前执行，只用来迁移数据，不参与checksum
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+	Apply   func(db *gorm.DB) error
+	Revert  func(db *gorm.DB) error
+}
+
+func (m Migration) Checksum() string {
+	h := md5.New()
+	io.WriteString(h, m.Name)
+	for _, sql := range m.Up {
+		io.WriteString(h, "\nup:"+sql)
+	}
+	for _, sql := range m.Down {
+		io.WriteString(h, "\ndown:"+sql)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+//已执行的migration
+type SchemaMigration struct {
+	Version   int       `json:"version"          gorm:"primary_key;auto_increment:false"`
+	Name      string    `json:"name"             gorm:"type:varchar(128)"`
+	Checksum  string    `json:"checksum"         gorm:"type:varchar(32)"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "ant_schema_migrations"
+}
+
+func createTable(table string, columns ...string) string {
+	return fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(columns, ", "))
+}
+
+//v1创建的ant_snapshots和ant_subscribers，后面的版本删除列时按这些列重建
+var snapshotColumns = []string{
+	"snapshot_id varchar(36)",
+	"amount varchar(36)",
+	"trace_id varchar(36)",
+	"user_id varchar(36)",
+	"opponent_id varchar(36)",
+	"data varchar(255)",
+	"created_at timestamp",
+	"asset_id varchar(36)",
+	"PRIMARY KEY (snapshot_id)",
+}
+
+var subscriberColumns = []string{
+	"user_id varchar(36)",
+	"created_at datetime",
+	"PRIMARY KEY (user_id)",
+}
+
+//一张表的建表语句和索引
+type tableSchema struct {
+	name    string
+	columns []string
+	indexes []string
+}
+
+//v1的表，已有数据库中由AutoMigrate创建的同名表在baseline中补齐缺少的列和索引
+var baselineTables = []tableSchema{
+	{
+		name:    "ant_snapshots",
+		columns: snapshotColumns,
+	},
+	{
+		name: "ant_profit_events",
+		columns: []string{
+			"id varchar(36)",
+			"category varchar(10)",
+			"strategy varchar(16)",
+			"price varchar(36)",
+			"profit varchar(36)",
+			"amount varchar(36)",
+			"min varchar(36)",
+			"max varchar(36)",
+			"base varchar(36)",
+			"quote varchar(36)",
+			"created_at datetime",
+			"expire bigint(36)",
+			"base_amount varchar(36)",
+			"quote_amount varchar(36)",
+			"exchange_order varchar(36)",
+			"otc_order varchar(36)",
+			"PRIMARY KEY (id)",
+		},
+	},
+	{
+		name:    "ant_subscribers",
+		columns: subscriberColumns,
+	},
+	{
+		name: "ant_checkpoints",
+		columns: []string{
+			"`key` varchar(64)",
+			"value varchar(255)",
+			"updated_at datetime",
+			"PRIMARY KEY (`key`)",
+		},
+	},
+	{
+		name: "ant_hedge_orders",
+		columns: []string{
+			"id varchar(36)",
+			"trace_id varchar(36)",
+			"event_id varchar(36)",
+			"side varchar(10)",
+			"base varchar(36)",
+			"quote varchar(36)",
+			"amount varchar(36)",
+			"price varchar(36)",
+			"residual boolean",
+			"created_at datetime",
+			"PRIMARY KEY (id)",
+		},
+		indexes: []string{
+			"CREATE INDEX idx_ant_hedge_orders_trace_id ON ant_hedge_orders(trace_id)",
+			"CREATE INDEX idx_ant_hedge_orders_event_id ON ant_hedge_orders(event_id)",
+		},
+	},
+	{
+		name: "ant_unwinds",
+		columns: []string{
+			"id varchar(36)",
+			"event_id varchar(36)",
+			"route varchar(64)",
+			"asset varchar(36)",
+			"amount varchar(36)",
+			"cost varchar(36)",
+			"returned varchar(36)",
+			"loss varchar(36)",
+			"stuck varchar(36)",
+			"created_at datetime",
+			"PRIMARY KEY (id)",
+		},
+		indexes: []string{
+			"CREATE INDEX idx_ant_unwinds_event_id ON ant_unwinds(event_id)",
+		},
+	},
+	{
+		name: "ant_ledger_entries",
+		columns: []string{
+			"id varchar(36)",
+			"event_id varchar(36)",
+			"snapshot_id varchar(36)",
+			"venue varchar(8)",
+			"asset_id varchar(36)",
+			"amount varchar(36)",
+			"fee varchar(36)",
+			"fee_asset varchar(36)",
+			"created_at datetime",
+			"PRIMARY KEY (id)",
+		},
+		indexes: []string{
+			"CREATE INDEX idx_ant_ledger_entries_event_id ON ant_ledger_entries(event_id)",
+			"CREATE INDEX idx_ant_ledger_entries_snapshot_id ON ant_ledger_entries(snapshot_id)",
+		},
+	},
+	{
+		name: "ant_event_pnls",
+		columns: []string{
+			"event_id varchar(36)",
+			"strategy varchar(16)",
+			"base varchar(36)",
+			"quote varchar(36)",
+			"day varchar(10)",
+			"base_amount varchar(36)",
+			"quote_amount varchar(36)",
+			"fee varchar(36)",
+			"pn_l varchar(36)",
+			"reference varchar(36)",
+			"updated_at datetime",
+			"PRIMARY KEY (event_id)",
+		},
+		indexes: []string{
+			"CREATE INDEX idx_ant_event_pnls_day ON ant_event_pnls(day)",
+		},
+	},
+	{
+		name: "ant_valuations",
+		columns: []string{
+			"id varchar(36)",
+			"btc varchar(36)",
+			"usdt varchar(36)",
+			"baseline_btc varchar(36)",
+			"baseline_usdt varchar(36)",
+			"assets text",
+			"missing text",
+			"created_at datetime",
+			"PRIMARY KEY (id)",
+		},
+		indexes: []string{
+			"CREATE INDEX idx_ant_valuations_created_at ON ant_valuations(created_at)",
+		},
+	},
+}
+
+func createTables(tables []tableSchema) []string {
+	statements := make([]string, 0)
+	for _, t := range tables {
+		statements = append(statements, createTable(t.name, t.columns...))
+		statements = append(statements, t.indexes...)
+	}
+	return statements
+}
+
+//没有迁移记录、但已有AutoMigrate创建的表时，补齐v1的表、列和索引，并把v1记为已执行
+func baseline(db *gorm.DB, v1 Migration) (bool, error) {
+	dialect := db.Dialect()
+	existing := false
+	for _, t := range baselineTables {
+		existing = existing || dialect.HasTable(t.name)
+	}
+	if !existing {
+		return false, nil
+	}
+
+	log.Printf("migrate baseline %d %s", v1.Version, v1.Name)
+	for _, t := range baselineTables {
+		if !dialect.HasTable(t.name) {
+			if err := execStatements(db, createTables([]tableSchema{t})); err != nil {
+				return false, err
+			}
+			continue
+		}
+		for _, column := range t.columns {
+			if strings.HasPrefix(column, "PRIMARY KEY") {
+				continue
+			}
+			name := strings.Trim(strings.Fields(column)[0], "`")
+			if dialect.HasColumn(t.name, name) {
+				continue
+			}
+			if err := execStatements(db, []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", t.name, column)}); err != nil {
+				return false, err
+			}
+		}
+		//CREATE INDEX <name> ON ...
+		for _, index := range t.indexes {
+			if dialect.HasIndex(t.name, strings.Fields(index)[2]) {
+				continue
+			}
+			if err := execStatements(db, []string{index}); err != nil {
+				return false, err
+			}
+		}
+	}
+	row := SchemaMigration{Version: v1.Version, Name: v1.Name, Checksum: v1.Checksum(), AppliedAt: time.Now()}
+	return true, db.Create(&row).Error
+}
+
+//删除列：mysql直接DROP COLUMN；sqlite 3.35之前不支持，按保留的列重建表，再恢复indexes中的索引
+func dropColumns(dialect, table string, dropped, kept []string, indexes ...string) []string {
+	if dialect == StoreMySQL {
+		statements := make([]string, 0, len(dropped))
+		for _, column := range dropped {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+		}
+		return statements
+	}
+	names := make([]string, 0, len(kept))
+	for _, column := range kept {
+		if !strings.HasPrefix(column, "PRIMARY KEY") {
+			names = append(names, strings.Fields(column)[0])
+		}
+	}
+	rebuild := table + "_rebuild"
+	statements := []string{
+		createTable(rebuild, kept...),
+		fmt.Sprintf("INSERT INTO %s SELECT %s FROM %s", rebuild, strings.Join(names, ", "), table),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rebuild, table),
+	}
+	return append(statements, indexes...)
+}
+
+//mysql和sqlite删除索引的语法不同
+func dropIndex(dialect, table, index string) string {
+	if dialect == StoreMySQL {
+		return fmt.Sprintf("DROP INDEX %s ON %s", index, table)
+	}
+	return fmt.Sprintf("DROP INDEX %s", index)
+}
+
+//按版本排列的migration，只能在末尾追加，已发布的不能修改
+func Migrations(dialect string) []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "create tables",
+			Up:      createTables(baselineTables),
+			Down: []string{
+				"DROP TABLE ant_valuations",
+				"DROP TABLE ant_event_pnls",
+				"DROP TABLE ant_ledger_entries",
+				"DROP TABLE ant_unwinds",
+				"DROP TABLE ant_hedge_orders",
+				"DROP TABLE ant_checkpoints",
+				"DROP TABLE ant_subscribers",
+				"DROP TABLE ant_profit_events",
+				"DROP TABLE ant_snapshots",
+			},
+		},
+		{
+			Version: 2,
+			Name:    "index ant_snapshots.trace_id",
+			Up:      []string{"CREATE INDEX idx_ant_snapshots_trace_id ON ant_snapshots(trace_id)"},
+			Down:    []string{dropIndex(dialect, "ant_snapshots", "idx_ant_snapshots_trace_id")},
+		},
+		{
+			Version: 3,
+			Name:    "index ant_profit_events.created_at",
+			Up:      []string{"CREATE INDEX idx_ant_profit_events_created_at ON ant_profit_events(created_at)"},
+			Down:    []string{dropIndex(dialect, "ant_profit_events", "idx_ant_profit_events_created_at")},
+		},
+		{
+			Version: 4,
+			Name:    "create ant_balance_discrepancies",
+			Up: []string{
+				createTable("ant_balance_discrepancies",
+					"id varchar(36)",
+					"asset_id varchar(36)",
+					"expected varchar(36)",
+					"actual varchar(36)",
+					"diff varchar(36)",
+					"drifts int",
+					"created_at datetime",
+					"PRIMARY KEY (id)",
+				),
+				"CREATE INDEX idx_ant_balance_discrepancies_asset_id ON ant_balance_discrepancies(asset_id)",
+			},
+			Down: []string{"DROP TABLE ant_balance_discrepancies"},
+		},
+		{
+			Version: 5,
+			Name:    "decode snapshot memos",
+			Up: []string{
+				"ALTER TABLE ant_snapshots ADD COLUMN venue varchar(8)",
+				"ALTER TABLE ant_snapshots ADD COLUMN reply_type varchar(16)",
+				"ALTER TABLE ant_snapshots ADD COLUMN order_id varchar(36)",
+				"ALTER TABLE ant_snapshots ADD COLUMN ask_order_id varchar(36)",
+				"ALTER TABLE ant_snapshots ADD COLUMN bid_order_id varchar(36)",
+				"ALTER TABLE ant_snapshots ADD COLUMN price varchar(36)",
+				"ALTER TABLE ant_snapshots ADD COLUMN fee varchar(36)",
+				"ALTER TABLE ant_snapshots ADD COLUMN fee_asset varchar(36)",
+				"CREATE INDEX idx_ant_snapshots_venue ON ant_snapshots(venue)",
+				"CREATE INDEX idx_ant_snapshots_reply_type ON ant_snapshots(reply_type)",
+				"CREATE INDEX idx_ant_snapshots_order_id ON ant_snapshots(order_id)",
+				"CREATE INDEX idx_ant_snapshots_ask_order_id ON ant_snapshots(ask_order_id)",
+				"CREATE INDEX idx_ant_snapshots_bid_order_id ON ant_snapshots(bid_order_id)",
+			},
+			Apply: decodeSnapshots,
+			Down: append([]string{
+				dropIndex(dialect, "ant_snapshots", "idx_ant_snapshots_venue"),
+				dropIndex(dialect, "ant_snapshots", "idx_ant_snapshots_reply_type"),
+				dropIndex(dialect, "ant_snapshots", "idx_ant_snapshots_order_id"),
+				dropIndex(dialect, "ant_snapshots", "idx_ant_snapshots_ask_order_id"),
+				dropIndex(dialect, "ant_snapshots", "idx_ant_snapshots_bid_order_id"),
+			}, dropColumns(dialect, "ant_snapshots",
+				[]string{"venue", "reply_type", "order_id", "ask_order_id", "bid_order_id", "price", "fee", "fee_asset"},
+				snapshotColumns,
+				"CREATE INDEX idx_ant_snapshots_trace_id ON ant_snapshots(trace_id)",
+			)...),
+		},
+		{
+			Version: 6,
+			Name:    "create ant_spreads",
+			Up: []string{
+				createTable("ant_spreads",
+					"id varchar(36)",
+					"base varchar(36)",
+					"quote varchar(36)",
+					"resolution varchar(4)",
+					"ocean_bid varchar(36)",
+					"ocean_ask varchar(36)",
+					"exin_bid varchar(36)",
+					"exin_ask varchar(36)",
+					"bid_spread varchar(36)",
+					"ask_spread varchar(36)",
+					"max_bid_spread varchar(36)",
+					"max_ask_spread varchar(36)",
+					"samples int",
+					"created_at datetime",
+					"PRIMARY KEY (id)",
+				),
+				"CREATE INDEX idx_ant_spreads_pair ON ant_spreads(base, quote, resolution)",
+				"CREATE INDEX idx_ant_spreads_created_at ON ant_spreads(created_at)",
+			},
+			Down: []string{"DROP TABLE ant_spreads"},
+		},
+		{
+			Version: 7,
+			Name:    "subscriber preferences",
+			Up: []string{
+				"ALTER TABLE ant_subscribers ADD COLUMN pairs varchar(255)",
+				"ALTER TABLE ant_subscribers ADD COLUMN side varchar(10)",
+				"ALTER TABLE ant_subscribers ADD COLUMN min_profit varchar(36)",
+				"ALTER TABLE ant_subscribers ADD COLUMN min_amount varchar(36)",
+				"ALTER TABLE ant_subscribers ADD COLUMN quiet_start varchar(5)",
+				"ALTER TABLE ant_subscribers ADD COLUMN quiet_end varchar(5)",
+				"ALTER TABLE ant_subscribers ADD COLUMN timezone varchar(64)",
+				"UPDATE ant_subscribers SET min_profit='0' WHERE min_profit IS NULL",
+				"UPDATE ant_subscribers SET min_amount='0' WHERE min_amount IS NULL",
+			},
+			Down: dropColumns(dialect, "ant_subscribers",
+				[]string{"pairs", "side", "min_profit", "min_amount", "quiet_start", "quiet_end", "timezone"},
+				subscriberColumns,
+			),
+		},
+		{
+			Version: 8,
+			Name:    "create ant_audit_logs",
+			Up: []string{
+				createTable("ant_audit_logs",
+					"id varchar(36)",
+					"user_id varchar(36)",
+					"command varchar(32)",
+					"args varchar(255)",
+					"error varchar(255)",
+					"created_at datetime",
+					"PRIMARY KEY (id)",
+				),
+				"CREATE INDEX idx_ant_audit_logs_user_id ON ant_audit_logs(user_id)",
+				"CREATE INDEX idx_ant_audit_logs_created_at ON ant_audit_logs(created_at)",
+			},
+			Down: []string{"DROP TABLE ant_audit_logs"},
+		},
+	}
+}
+
+//解码已有snapshot的memo，填入v5添加的列
+func decodeSnapshots(db *gorm.DB) error {
+	const batch = 500
+	for offset := 0; ; offset += batch {
+		var snapshots []Snapshot
+		if err := db.Where("opponent_id IN (?)", []string{OceanCore, ExinCore}).Order("snapshot_id").Offset(offset).Limit(batch).Find(&snapshots).Error; err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			if err := s.Decode(); err != nil {
+				log.Println("decode snapshot error", s.SnapshotId, err)
+			}
+			updates := map[string]interface{}{
+				"venue":        s.Venue,
+				"reply_type":   s.ReplyType,
+				"order_id":     s.OrderId,
+				"ask_order_id": s.AskOrderId,
+				"bid_order_id": s.BidOrderId,
+				"price":        s.Price,
+				"fee":          s.Fee,
+				"fee_asset":    s.FeeAsset,
+			}
+			if err := db.Model(&Snapshot{}).Where("snapshot_id=?", s.SnapshotId).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if len(snapshots) < batch {
+			return nil
+		}
+	}
+}
+
+//已执行的migration，同时检查checksum是否和定义一致
+func appliedMigrations(db *gorm.DB, migrations []Migration) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	defined := make(map[int]Migration, len(migrations))
+	for _, m := range migrations {
+		defined[m.Version] = m
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		m, ok := defined[row.Version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d %q is applied but not defined", row.Version, row.Name)
+		}
+		if m.Checksum() != row.Checksum {
+			return nil, fmt.Errorf("migration %d %q has been changed after it was applied", row.Version, row.Name)
+		}
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func execStatements(db *gorm.DB, statements []string) error {
+	for _, sql := range statements {
+		if err := db.Exec(sql).Error; err != nil {
+			return fmt.Errorf("%s: %v", sql, err)
+		}
+	}
+	return nil
+}
+
+//先改表结构再迁移数据，回滚时顺序相反
+func migrateUp(db *gorm.DB, m Migration) error {
+	if err := execStatements(db, m.Up); err != nil {
+		return err
+	}
+	if m.Apply != nil {
+		return m.Apply(db)
+	}
+	return nil
+}
+
+func migrateDown(db *gorm.DB, m Migration) error {
+	if m.Revert != nil {
+		if err := m.Revert(db); err != nil {
+			return err
+		}
+	}
+	return execStatements(db, m.Down)
+}
+
+//升级或回滚到target版本，target小于0时升级到最新
+func Migrate(db *gorm.DB, target int) error {
+	migrations := Migrations(db.Dialect().GetName())
+	applied, err := appliedMigrations(db, migrations)
+	if err != nil {
+		return err
+	}
+	if target < 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+	if len(applied) == 0 && target > 0 {
+		ok, err := baseline(db, migrations[0])
+		if err != nil {
+			return fmt.Errorf("baseline: %v", err)
+		}
+		if ok {
+			applied[migrations[0].Version] = SchemaMigration{Version: migrations[0].Version}
+		}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok || m.Version > target {
+			continue
+		}
+		log.Printf("migrate up %d %s", m.Version, m.Name)
+		if err := migrateUp(db, m); err != nil {
+			return fmt.Errorf("migration %d: %v", m.Version, err)
+		}
+		row := SchemaMigration{Version: m.Version, Name: m.Name, Checksum: m.Checksum(), AppliedAt: time.Now()}
+		if err := db.Create(&row).Error; err != nil {
+			return err
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok || m.Version <= target {
+			continue
+		}
+		log.Printf("migrate down %d %s", m.Version, m.Name)
+		if err := migrateDown(db, m); err != nil {
+			return fmt.Errorf("migration %d: %v", m.Version, err)
+		}
+		if err := db.Where("version=?", m.Version).Delete(&SchemaMigration{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//每个migration的状态，用于命令行显示
+func MigrationStatus(db *gorm.DB) ([]string, error) {
+	migrations := Migrations(db.Dialect().GetName())
+	applied, err := appliedMigrations(db, migrations)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0, len(migrations))
+	for _, m := range migrations {
+		state := "pending"
+		if row, ok := applied[m.Version]; ok {
+			state = "applied " + row.AppliedAt.Format(time.RFC3339)
+		}
+		lines = append(lines, strings.Join([]string{fmt.Sprintf("%4d", m.Version), m.Checksum()[:8], m.Name, state}, "  "))
+	}
+	return lines, nil
+}
@@ -0,0 +1,193 @@
+package ant
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/shopspring/decimal"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(StoreSQLite, filepath.Join(t.TempDir(), "ant.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+//写死的DDL要和结构体一致，否则读写时会缺列
+func TestMigrationsMatchModels(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	models := []interface{}{
+		&Snapshot{},
+		&ProfitEvent{},
+		&Subscriber{},
+		&Checkpoint{},
+		&HedgeOrder{},
+		&Unwind{},
+		&LedgerEntry{},
+		&EventPnL{},
+		&Valuation{},
+		&BalanceDiscrepancy{},
+		&SpreadSample{},
+		&AuditLog{},
+	}
+	for _, model := range models {
+		scope := db.NewScope(model)
+		table := scope.TableName()
+		if !db.Dialect().HasTable(table) {
+			t.Errorf("table %s is missing", table)
+			continue
+		}
+		for _, field := range scope.GetModelStruct().StructFields {
+			if field.IsNormal && !db.Dialect().HasColumn(table, field.DBName) {
+				t.Errorf("column %s.%s is missing", table, field.DBName)
+			}
+		}
+	}
+}
+
+func TestMigrateTwice(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.Model(&SchemaMigration{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if migrations := Migrations(StoreSQLite); count != len(migrations) {
+		t.Fatalf("%d migrations recorded, want %d", count, len(migrations))
+	}
+}
+
+//sqlite 3.35之前不支持DROP COLUMN，回滚时重建表，数据要保留
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	store := NewSQLStore(db)
+	snapshot := &Snapshot{SnapshotId: UuidWithString("snapshot"), Amount: "1", TraceId: UuidWithString("trace")}
+	if err := store.SaveSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Subscribe(UuidWithString("user")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, 4); err != nil {
+		t.Fatal(err)
+	}
+	for table, column := range map[string]string{"ant_snapshots": "venue", "ant_subscribers": "min_profit"} {
+		if db.Dialect().HasColumn(table, column) {
+			t.Fatalf("column %s.%s not dropped", table, column)
+		}
+	}
+	if !db.Dialect().HasIndex("ant_snapshots", "idx_ant_snapshots_trace_id") {
+		t.Fatal("index on trace_id lost")
+	}
+	var count int
+	if err := db.Table("ant_snapshots").Where("snapshot_id=?", snapshot.SnapshotId).Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("snapshot lost after rollback: %d %v", count, err)
+	}
+	if err := db.Table("ant_subscribers").Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("subscriber lost after rollback: %d %v", count, err)
+	}
+
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := store.FindSubscriber(UuidWithString("user")); err != nil || s == nil || !s.MinProfit.IsZero() {
+		t.Fatalf("subscriber after upgrade: %v %v", s, err)
+	}
+	if err := Migrate(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if db.Dialect().HasTable("ant_snapshots") {
+		t.Fatal("tables left after rolling back everything")
+	}
+}
+
+//升级前由db.AutoMigrate创建的表
+type legacySnapshot struct {
+	SnapshotId string    `gorm:"primary_key;type:varchar(36)"`
+	Amount     string    `gorm:"type:varchar(36)"`
+	TraceId    string    `gorm:"type:varchar(36)"`
+	UserId     string    `gorm:"type:varchar(36)"`
+	OpponentId string    `gorm:"type:varchar(36)"`
+	Data       string    `gorm:"type:varchar(255)"`
+	CreatedAt  time.Time `gorm:"type:timestamp"`
+	AssetId    string    `gorm:"type:varchar(36)"`
+}
+
+func (legacySnapshot) TableName() string {
+	return "ant_snapshots"
+}
+
+type legacyProfitEvent struct {
+	ID            string          `gorm:"type:varchar(36);primary_key"`
+	Category      string          `gorm:"type:varchar(10)"`
+	Price         decimal.Decimal `gorm:"type:varchar(36)"`
+	Profit        decimal.Decimal `gorm:"type:varchar(36)"`
+	Amount        decimal.Decimal `gorm:"type:varchar(36)"`
+	Min           decimal.Decimal `gorm:"type:varchar(36)"`
+	Max           decimal.Decimal `gorm:"type:varchar(36)"`
+	Base          string          `gorm:"type:varchar(36)"`
+	Quote         string          `gorm:"type:varchar(36)"`
+	CreatedAt     time.Time
+	Expire        int64           `gorm:"type:bigint(36)"`
+	BaseAmount    decimal.Decimal `gorm:"type:varchar(36)"`
+	QuoteAmount   decimal.Decimal `gorm:"type:varchar(36)"`
+	ExchangeOrder string          `gorm:"type:varchar(36);"`
+	OtcOrder      string          `gorm:"type:varchar(36);"`
+}
+
+func (legacyProfitEvent) TableName() string {
+	return "ant_profit_events"
+}
+
+//已有AutoMigrate建的表时，v1只补齐缺少的表和列，数据保留
+func TestMigrateFromAutoMigrate(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&legacySnapshot{}, &legacyProfitEvent{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	snapshot := legacySnapshot{SnapshotId: UuidWithString("snapshot"), Amount: "1", OpponentId: OceanCore, CreatedAt: time.Now()}
+	if err := db.Create(&snapshot).Error; err != nil {
+		t.Fatal(err)
+	}
+	event := legacyProfitEvent{ID: UuidWithString("event"), Category: PageSideBid, Price: dec("10000"), CreatedAt: time.Now()}
+	if err := db.Create(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	for table, column := range map[string]string{"ant_profit_events": "strategy", "ant_snapshots": "venue", "ant_hedge_orders": "trace_id"} {
+		if !db.Dialect().HasColumn(table, column) {
+			t.Fatalf("column %s.%s is missing", table, column)
+		}
+	}
+	found, err := NewSQLStore(db).FindEvent(event.ID)
+	if err != nil || found == nil || !found.Price.Equal(event.Price) {
+		t.Fatalf("event after baseline: %v %v", found, err)
+	}
+	if ok, err := NewSQLStore(db).SnapshotExists(snapshot.SnapshotId); err != nil || !ok {
+		t.Fatalf("snapshot after baseline: %v %v", ok, err)
+	}
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+}
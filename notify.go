@@ -0,0 +1,257 @@
+package ant
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	bot "github.com/MixinNetwork/bot-api-go-client"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	NoticePost = "post"
+	NoticeCard = "card"
+	NoticeText = "text"
+
+	MessageCategoryPlainPost = "PLAIN_POST"
+	MessageCategoryAppCard   = "APP_CARD"
+)
+
+//推送的格式，post为markdown，card为app卡片加按钮，text为纯文本加按钮
+var NoticeFormat = NoticePost
+
+//模板所在的目录，目录中的 opportunity.md、opportunity.json、opportunity.txt 覆盖内置的模板，
+//文件修改后下一次推送时自动重新加载
+var NoticeTemplateDir = ""
+
+//app卡片的图标
+var NoticeIcon = ""
+
+//内置模板，文件名和格式对应
+var defaultNoticeTemplates = map[string]string{
+	NoticePost: `**{{.Action}} {{.Pair}}**
+
+| | Price |
+|---|---|
+| [Mixcoin]({{.OceanURL}}) | {{.OceanPrice}} |
+| [ExinOne]({{.ExinURL}}) | {{.ExinPrice}} |
+
+- Amount: {{.Amount}} {{.BaseSymbol}} ≈ {{.Funds}} {{.QuoteSymbol}}
+- Net profit: **{{percent .NetProfit}}%** after fees
+- Expires in {{.ExpiresIn}}
+`,
+	NoticeCard: `{
+  "app_id": {{json .AppId}},
+  "icon_url": {{json .Icon}},
+  "title": {{json (printf "%s %s +%s%%" .Action .Pair (percent .NetProfit))}},
+  "description": {{json (printf "Mixcoin %s / ExinOne %s, %s %s, %s left" .OceanPrice .ExinPrice .Amount .BaseSymbol .ExpiresIn)}},
+  "action": {{json .ExinURL}}
+}
+`,
+	NoticeText: `{{.Action}} {{.Pair}}
+Mixcoin: {{.OceanPrice}}
+ExinOne: {{.ExinPrice}}
+Amount: {{.Amount}} {{.BaseSymbol}}
+Net profit: {{percent .NetProfit}}%
+Expires in {{.ExpiresIn}}
+`,
+}
+
+var noticeTemplateFiles = map[string]string{
+	NoticePost: "opportunity.md",
+	NoticeCard: "opportunity.json",
+	NoticeText: "opportunity.txt",
+}
+
+var noticeFuncs = template.FuncMap{
+	//小数表示的收益率换成百分数
+	"percent": func(d decimal.Decimal) string {
+		return d.Mul(decimal.NewFromFloat(100)).Round(2).String()
+	},
+	"json": func(v interface{}) (string, error) {
+		bt, err := json.Marshal(v)
+		return string(bt), err
+	},
+}
+
+//模板中可以使用的字段
+type NoticeData struct {
+	Action      string
+	Side        string
+	Pair        string
+	Base        string
+	Quote       string
+	BaseSymbol  string
+	QuoteSymbol string
+	OceanPrice  decimal.Decimal
+	ExinPrice   decimal.Decimal
+	Amount      decimal.Decimal
+	Funds       decimal.Decimal
+	Profit      decimal.Decimal
+	NetProfit   decimal.Decimal
+	ExpiresIn   time.Duration
+	OceanURL    string
+	ExinURL     string
+	AppId       string
+	Icon        string
+}
+
+//扣除两边手续费后的收益率
+func NetProfit(profit decimal.Decimal) decimal.Decimal {
+	one := decimal.NewFromFloat(1.0)
+	return one.Add(profit).Mul(decimal.NewFromFloat((1 - OceanFee) * (1 - ExinFee))).Sub(one)
+}
+
+//exin的价格按Inspect中的收益率反推：在ocean.one卖出时 profit=(ocean-exin)/exin，买入时 profit=(exin-ocean)/exin
+func NewNoticeData(event *ProfitEvent, now time.Time) *NoticeData {
+	one := decimal.NewFromFloat(1.0)
+	pair := Who(event.Base) + "/" + Who(event.Quote)
+	data := &NoticeData{
+		Action:      map[string]string{PageSideBid: "Buy in Mixcoin", PageSideAsk: "Sell in Mixcoin"}[event.Category],
+		Side:        event.Category,
+		Pair:        pair,
+		Base:        event.Base,
+		Quote:       event.Quote,
+		BaseSymbol:  Who(event.Base),
+		QuoteSymbol: Who(event.Quote),
+		OceanPrice:  event.Price,
+		ExinPrice:   event.Price,
+		Amount:      event.Amount,
+		Funds:       event.Amount.Mul(event.Price).Round(8),
+		Profit:      event.Profit,
+		NetProfit:   NetProfit(event.Profit),
+		OceanURL:    OceanWebsite,
+		ExinURL:     fmt.Sprintf(ExinWebsite, PairIndex[pair]),
+		AppId:       ClientId,
+		Icon:        NoticeIcon,
+	}
+	if event.Category == PageSideAsk {
+		data.ExinPrice = event.Price.Div(one.Add(event.Profit))
+	} else if one.Sub(event.Profit).IsPositive() {
+		data.ExinPrice = event.Price.Div(one.Sub(event.Profit))
+	}
+	data.ExinPrice = data.ExinPrice.Round(8)
+	if left := event.CreatedAt.Add(time.Duration(event.Expire)).Sub(now); left > 0 {
+		data.ExpiresIn = left.Round(time.Second)
+	}
+	return data
+}
+
+type cachedTemplate struct {
+	modTime  time.Time
+	template *template.Template
+}
+
+//按文件修改时间缓存的模板
+type noticeTemplates struct {
+	mutex sync.Mutex
+	cache map[string]*cachedTemplate
+}
+
+var templates = &noticeTemplates{cache: make(map[string]*cachedTemplate, 0)}
+
+func (t *noticeTemplates) get(format string) (*template.Template, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	text, ok := defaultNoticeTemplates[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown notice format %q", format)
+	}
+	var modTime time.Time
+	if NoticeTemplateDir != "" {
+		path := filepath.Join(NoticeTemplateDir, noticeTemplateFiles[format])
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+			if cached, ok := t.cache[format]; ok && cached.modTime.Equal(modTime) {
+				return cached.template, nil
+			}
+			bt, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			text = string(bt)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if cached, ok := t.cache[format]; ok && cached.modTime.Equal(modTime) {
+		return cached.template, nil
+	}
+	tmpl, err := template.New(format).Funcs(noticeFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[format] = &cachedTemplate{modTime: modTime, template: tmpl}
+	return tmpl, nil
+}
+
+//按NoticeFormat渲染出消息的类型和内容，card渲染后检查是否为合法的json
+func RenderNotice(format string, data *NoticeData) (string, []byte, error) {
+	tmpl, err := templates.get(format)
+	if err != nil {
+		return "", nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", nil, err
+	}
+	switch format {
+	case NoticePost:
+		return MessageCategoryPlainPost, buf.Bytes(), nil
+	case NoticeCard:
+		var card map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &card); err != nil {
+			return "", nil, fmt.Errorf("card template: %v", err)
+		}
+		bt, err := json.Marshal(card)
+		return MessageCategoryAppCard, bt, err
+	}
+	return bot.MessageCategoryPlainText, buf.Bytes(), nil
+}
+
+//通过HTTP接口发送消息，用于blaze客户端没有封装的消息类型
+func SendMessage(ctx context.Context, conversationId, recipientId, category string, data []byte) error {
+	body, err := json.Marshal(map[string]string{
+		"conversation_id": conversationId,
+		"recipient_id":    recipientId,
+		"message_id":      uuid.Must(uuid.NewV4()).String(),
+		"category":        category,
+		"data":            base64.StdEncoding.EncodeToString(data),
+	})
+	if err != nil {
+		return err
+	}
+	token, err := bot.SignAuthenticationToken(ClientId, SessionId, PrivateKey, "POST", "/messages", string(body))
+	if err != nil {
+		return err
+	}
+	resp, err := bot.Request(ctx, "POST", "/messages", body, token)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Error *struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return err
+	}
+	if result.Error != nil {
+		return errors.New(result.Error.Description)
+	}
+	return nil
+}
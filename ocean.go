@@ -17,6 +17,12 @@ const (
 	MaxPrice        = 1000000000
 	MaxAmount       = 5000000000
 	MaxFunds        = MaxPrice * MaxAmount
+
+	//ocean.one返回转账memo中的S
+	OceanSourceTradeConfirmed = "TRADE_CONFIRMED"
+	OceanSourceOrderCancelled = "ORDER_CANCELLED"
+	OceanSourceOrderFilled    = "ORDER_FILLED"
+	OceanSourceOrderInvalid   = "ORDER_INVALID"
 )
 
 var (
@@ -54,6 +60,9 @@ func (reply *OceanReply) Unpack(memo string) error {
 	return errors.New("TODO")
 }
 
+//策略通过它下单，测试中替换成模拟成交
+var oceanTrade = OceanTrade
+
 func OceanTrade(side, price, amount, category, base, quote string, trace ...string) (string, error) {
 	return "", errors.New("TODO")
 }
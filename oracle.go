@@ -0,0 +1,224 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//exin行情的缓存时间
+	ExinTickerTTL = 1 * time.Minute
+	//资产估值的间隔
+	ValuationInterval = 10 * time.Minute
+)
+
+//没有直接价格时经过这些资产折算
+var OracleBridges = []string{BTC, USDT}
+
+//资产价格，优先用ocean.one盘口的中间价，没有时用exin的报价，都没有时经过BTC或USDT折算
+type PriceOracle struct {
+	books     map[string]*OrderBook
+	mutex     sync.Mutex
+	tickers   map[string]map[string]decimal.Decimal
+	updatedAt map[string]time.Time
+}
+
+func NewPriceOracle(books map[string]*OrderBook) *PriceOracle {
+	return &PriceOracle{
+		books:     books,
+		tickers:   make(map[string]map[string]decimal.Decimal, 0),
+		updatedAt: make(map[string]time.Time, 0),
+	}
+}
+
+//ocean.one上的中间价，只有一边有挂单时用这一边
+func (o *PriceOracle) oceanMid(base, quote string) decimal.Decimal {
+	book, ok := o.books[base+"-"+quote]
+	if !ok {
+		return decimal.Zero
+	}
+	depth := book.GetDepth(1)
+	if depth == nil {
+		return decimal.Zero
+	}
+	switch {
+	case len(depth.Bids) > 0 && len(depth.Asks) > 0:
+		return depth.Bids[0].Price.Add(depth.Asks[0].Price).Div(decimal.NewFromFloat(2.0))
+	case len(depth.Bids) > 0:
+		return depth.Bids[0].Price
+	case len(depth.Asks) > 0:
+		return depth.Asks[0].Price
+	}
+	return decimal.Zero
+}
+
+//exin上以quote计价的所有报价，按ExinTickerTTL缓存
+func (o *PriceOracle) exinPrice(ctx context.Context, base, quote string) decimal.Decimal {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.updatedAt[quote].Add(ExinTickerTTL).Before(time.Now()) {
+		prices, err := GetExinPrices(ctx, quote)
+		if err != nil {
+			log.Println("exin prices error", err)
+		} else {
+			tickers := make(map[string]decimal.Decimal, len(prices))
+			for asset, price := range prices {
+				if p, err := decimal.NewFromString(price); err == nil {
+					tickers[asset] = p
+				}
+			}
+			o.tickers[quote] = tickers
+		}
+		//失败时也等一个周期再请求
+		o.updatedAt[quote] = time.Now()
+	}
+	return o.tickers[quote][base]
+}
+
+//不经过其他资产的价格，也尝试反向的交易对
+func (o *PriceOracle) direct(ctx context.Context, asset, quote string) decimal.Decimal {
+	if price := o.oceanMid(asset, quote); price.IsPositive() {
+		return price
+	}
+	if price := o.oceanMid(quote, asset); price.IsPositive() {
+		return decimal.NewFromFloat(1.0).Div(price)
+	}
+	if price := o.exinPrice(ctx, asset, quote); price.IsPositive() {
+		return price
+	}
+	if price := o.exinPrice(ctx, quote, asset); price.IsPositive() {
+		return decimal.NewFromFloat(1.0).Div(price)
+	}
+	return decimal.Zero
+}
+
+//asset以quote计的价格
+func (o *PriceOracle) Price(ctx context.Context, asset, quote string) (decimal.Decimal, error) {
+	if asset == quote {
+		return decimal.NewFromFloat(1.0), nil
+	}
+	if price := o.direct(ctx, asset, quote); price.IsPositive() {
+		return price, nil
+	}
+	for _, bridge := range OracleBridges {
+		if bridge == asset || bridge == quote {
+			continue
+		}
+		if first := o.direct(ctx, asset, bridge); first.IsPositive() {
+			if second := o.direct(ctx, bridge, quote); second.IsPositive() {
+				return first.Mul(second), nil
+			}
+		}
+	}
+	return decimal.Zero, fmt.Errorf("no price for %s/%s", Who(asset), Who(quote))
+}
+
+//按quote计的总价值，返回没有价格的资产
+func (o *PriceOracle) Value(ctx context.Context, balances map[string]decimal.Decimal, quote string) (decimal.Decimal, []string) {
+	total := decimal.Zero
+	missing := make([]string, 0)
+	for asset, balance := range balances {
+		if !balance.IsPositive() {
+			continue
+		}
+		price, err := o.Price(ctx, asset, quote)
+		if err != nil {
+			missing = append(missing, asset)
+			continue
+		}
+		total = total.Add(balance.Mul(price))
+	}
+	return total, missing
+}
+
+//某一时刻的资产估值，Baseline是Wallet中的初始资产按同一时刻价格的估值
+type Valuation struct {
+	ID           string          `json:"id"               gorm:"type:varchar(36);primary_key"`
+	BTC          decimal.Decimal `json:"btc"              gorm:"type:varchar(36)"`
+	USDT         decimal.Decimal `json:"usdt"             gorm:"type:varchar(36)"`
+	BaselineBTC  decimal.Decimal `json:"baseline_btc"     gorm:"type:varchar(36)"`
+	BaselineUSDT decimal.Decimal `json:"baseline_usdt"    gorm:"type:varchar(36)"`
+	Assets       string          `json:"assets"           gorm:"type:text"`
+	Missing      string          `json:"missing"          gorm:"type:text"`
+	CreatedAt    time.Time       `json:"created_at"       gorm:"index"`
+}
+
+func (Valuation) TableName() string {
+	return "ant_valuations"
+}
+
+func (ant *Ant) Valuate(ctx context.Context) (*Valuation, error) {
+	assets, err := ReadAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[string]decimal.Decimal, len(assets))
+	for asset, balance := range assets {
+		if b, err := decimal.NewFromString(balance); err == nil {
+			balances[asset] = b
+		}
+	}
+	baseline := make(map[string]decimal.Decimal, len(Wallet))
+	for asset, amount := range Wallet {
+		baseline[asset] = decimal.NewFromFloat(amount)
+	}
+
+	v := Valuation{ID: uuid.Must(uuid.NewV4()).String(), CreatedAt: time.Now()}
+	var missing []string
+	v.BTC, missing = ant.oracle.Value(ctx, balances, BTC)
+	v.USDT, _ = ant.oracle.Value(ctx, balances, USDT)
+	v.BaselineBTC, _ = ant.oracle.Value(ctx, baseline, BTC)
+	v.BaselineUSDT, _ = ant.oracle.Value(ctx, baseline, USDT)
+
+	symbols := make(map[string]string, len(balances))
+	for asset, balance := range balances {
+		if balance.IsPositive() {
+			symbols[Who(asset)] = balance.String()
+		}
+	}
+	if bt, err := json.Marshal(symbols); err == nil {
+		v.Assets = string(bt)
+	}
+	for i, asset := range missing {
+		missing[i] = Who(asset)
+	}
+	if bt, err := json.Marshal(missing); err == nil {
+		v.Missing = string(bt)
+	}
+	return &v, nil
+}
+
+//定时记录资产估值，用于画出资产曲线
+func (ant *Ant) RecordValuations(ctx context.Context) error {
+	ticker := time.NewTicker(ValuationInterval)
+	defer ticker.Stop()
+
+	record := func() {
+		v, err := ant.Valuate(ctx)
+		if err != nil {
+			log.Println("valuate error", err)
+			return
+		}
+		if err := Storage(ctx).SaveValuation(v); err != nil {
+			log.Println("create valuation error", err)
+		}
+	}
+
+	record()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			record()
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/emirpasic/gods/trees/redblacktree"
@@ -42,7 +43,14 @@ type OrderBook struct {
 	sequences map[string]bool
 	previous  int
 	pair      string
+	base      string
+	quote     string
 	trade     Trade
+	//本地最后一次更新的时间，ocean事件的时间戳，以及是否已收到BOOK-T0
+	clock     sync.Mutex
+	updatedAt time.Time
+	eventAt   time.Time
+	synced    bool
 }
 
 func NewBook(base, quote string) *OrderBook {
@@ -51,6 +59,8 @@ func NewBook(base, quote string) *OrderBook {
 		asks:      redblacktree.NewWith(NewComparer(PageSideAsk)),
 		sequences: make(map[string]bool, 0),
 		pair:      base + "-" + quote,
+		base:      base,
+		quote:     quote,
 	}
 }
 
@@ -79,6 +89,13 @@ func (book *OrderBook) GetDepth(limit int) *Depth {
 	return &depth
 }
 
+//盘口最后更新的时间、对应ocean事件的时间戳，以及断线重连后是否已重新同步
+func (book *OrderBook) Clock() (time.Time, time.Time, bool) {
+	book.clock.Lock()
+	defer book.clock.Unlock()
+	return book.updatedAt, book.eventAt, book.synced
+}
+
 func NewComparer(side string) func(a, b interface{}) int {
 	return func(a, b interface{}) int {
 		entry := a.(decimal.Decimal)
@@ -124,15 +141,26 @@ func (book *OrderBook) OnOrderMessage(msg *BlazeMessage) error {
 			return nil
 		}
 		if now != book.previous+1 {
+			previous := book.previous
 			book.asks.Clear()
 			book.bids.Clear()
 			book.previous = 0
 			book.sequences = make(map[string]bool, 0)
-			return fmt.Errorf("%s, previous %v, but now %v", WrongSequenceError, book.previous, now)
+			book.clock.Lock()
+			book.synced = false
+			book.clock.Unlock()
+			return fmt.Errorf("%s, previous %v, but now %v", WrongSequenceError, previous, now)
 		}
 	}
 
 	book.previous = now
+	book.clock.Lock()
+	book.updatedAt = time.Now()
+	book.eventAt = e.Timestamp
+	if e.Type == EventTypeBookT0 {
+		book.synced = true
+	}
+	book.clock.Unlock()
 
 	switch e.Type {
 	case EventTypeOrderOpen, EventTypeOrderCancel:
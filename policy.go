@@ -0,0 +1,76 @@
+package ant
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//吃单方式：市价单直接吃单，未成交部分由ocean.one退回；
+//limit单到期后撤单，相当于IOC；所有订单都登记在registry中，Lifetime必须大于0
+type OrderPolicy struct {
+	Type     string
+	Lifetime time.Duration
+}
+
+//各策略吃单的方式，做市策略自己管理撤单，不在这里配置
+var OrderPolicies = map[string]OrderPolicy{
+	StrategyArbitrage: {Type: OrderTypeLimit, Lifetime: time.Duration(OrderExpireTime)},
+	StrategyFishing:   {Type: OrderTypeLimit, Lifetime: time.Duration(6 * OrderExpireTime)},
+	StrategyTriangle:  {Type: OrderTypeLimit, Lifetime: time.Duration(OrderExpireTime)},
+	StrategyFallback:  {Type: OrderTypeLimit, Lifetime: time.Duration(OrderExpireTime)},
+}
+
+func PolicyOf(strategy string) OrderPolicy {
+	if policy, ok := OrderPolicies[strategy]; ok {
+		if policy.Type == OrderTypeLimit && policy.Lifetime <= 0 {
+			policy.Lifetime = time.Duration(OrderExpireTime)
+		}
+		return policy
+	}
+	return OrderPolicy{Type: OrderTypeLimit, Lifetime: time.Duration(OrderExpireTime)}
+}
+
+//市价单的价格不起作用
+func (policy OrderPolicy) Price(size *OrderSize) string {
+	if policy.Type == OrderTypeMarket {
+		return "0"
+	}
+	return size.Price.String()
+}
+
+//解析命令行中的 strategy=market 或 strategy=limit:5s
+func ParseOrderPolicy(spec string) (string, OrderPolicy, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return "", OrderPolicy{}, fmt.Errorf("invalid order policy %q", spec)
+	}
+	strategy := strings.ToLower(strings.TrimSpace(parts[0]))
+	if _, ok := OrderPolicies[strategy]; !ok {
+		return "", OrderPolicy{}, fmt.Errorf("unknown strategy %q", strategy)
+	}
+
+	values := strings.SplitN(strings.ToLower(strings.TrimSpace(parts[1])), ":", 2)
+	switch values[0] {
+	case "market":
+		return strategy, OrderPolicy{Type: OrderTypeMarket}, nil
+	case "limit":
+		policy := OrderPolicy{Type: OrderTypeLimit, Lifetime: PolicyOf(strategy).Lifetime}
+		if policy.Lifetime == 0 {
+			policy.Lifetime = time.Duration(OrderExpireTime)
+		}
+		if len(values) == 2 {
+			lifetime, err := time.ParseDuration(values[1])
+			if err != nil {
+				return "", OrderPolicy{}, err
+			}
+			//不撤单的订单会在registry过期后丢失后续的成交和退款
+			if lifetime <= 0 {
+				return "", OrderPolicy{}, fmt.Errorf("limit order lifetime must be positive, got %v", lifetime)
+			}
+			policy.Lifetime = lifetime
+		}
+		return strategy, policy, nil
+	}
+	return "", OrderPolicy{}, fmt.Errorf("unknown order type %q", values[0])
+}
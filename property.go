@@ -7,11 +7,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
 	"time"
 
 	bot "github.com/MixinNetwork/bot-api-go-client"
-	"github.com/shopspring/decimal"
 )
 
 var Wallet = map[string]float64{
@@ -125,38 +123,3 @@ func ReadSnapshot(ctx context.Context, id string) (string, error) {
 
 	return resp.Data.TraceId, nil
 }
-
-func SumAssetsNow(ctx context.Context) (float64, error) {
-	prices, err := GetExinPrices(ctx, BTC)
-	if err != nil {
-		return 0, err
-	}
-	assets, err := ReadAssets(ctx)
-	if err != nil {
-		return 0, err
-	}
-
-	sum, _ := decimal.NewFromString(assets[BTC])
-	for asset, balance := range assets {
-		price, _ := decimal.NewFromString(prices[asset])
-		amount, _ := decimal.NewFromString(balance)
-		sum = sum.Add(price.Mul(amount))
-	}
-	s, _ := sum.Float64()
-	return s, nil
-}
-
-func SumAssetsInit(ctx context.Context) (float64, error) {
-	prices, err := GetExinPrices(ctx, BTC)
-	if err != nil {
-		return 0, err
-	}
-
-	sum := Wallet[BTC]
-	for asset, amount := range Wallet {
-		price, _ := strconv.ParseFloat(prices[asset], 64)
-		sum += price * amount
-	}
-
-	return sum, nil
-}
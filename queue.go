@@ -0,0 +1,171 @@
+package ant
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//超过该时间还没执行的机会直接丢弃
+	OpportunityTTL = 1 * time.Second
+	//每个交易对同时在途的订单数
+	PairExecutionLimit = 1
+)
+
+type opportunity struct {
+	key      string
+	event    *ProfitEvent
+	expected decimal.Decimal
+	index    int
+}
+
+type opportunityHeap []*opportunity
+
+func (h opportunityHeap) Len() int { return len(h) }
+
+func (h opportunityHeap) Less(i, j int) bool {
+	return h[i].expected.GreaterThan(h[j].expected)
+}
+
+func (h opportunityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *opportunityHeap) Push(x interface{}) {
+	item := x.(*opportunity)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *opportunityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+//按预期净收益排序的套利机会，同一交易对同一方向只保留最新的一个
+type OpportunityQueue struct {
+	mutex  sync.Mutex
+	items  opportunityHeap
+	keys   map[string]*opportunity
+	notify chan struct{}
+	ttl    time.Duration
+	//交易对是否已达到同时执行的上限
+	busy func(pair string) bool
+}
+
+func NewOpportunityQueue(ttl time.Duration, busy func(pair string) bool) *OpportunityQueue {
+	return &OpportunityQueue{
+		items:  make(opportunityHeap, 0),
+		keys:   make(map[string]*opportunity, 0),
+		notify: make(chan struct{}, 1),
+		ttl:    ttl,
+		busy:   busy,
+	}
+}
+
+func (q *OpportunityQueue) Push(event *ProfitEvent, expected decimal.Decimal) {
+	q.mutex.Lock()
+	key := event.Base + event.Quote + event.Category
+	if item, ok := q.keys[key]; ok {
+		item.event = event
+		item.expected = expected
+		heap.Fix(&q.items, item.index)
+	} else {
+		item := &opportunity{key: key, event: event, expected: expected}
+		heap.Push(&q.items, item)
+		q.keys[key] = item
+	}
+	q.mutex.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *OpportunityQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items)
+}
+
+//取出收益最高、未过期且交易对有空闲的机会，没有时返回nil
+func (q *OpportunityQueue) next() *ProfitEvent {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	skipped := make([]*opportunity, 0)
+	defer func() {
+		for _, item := range skipped {
+			heap.Push(&q.items, item)
+		}
+	}()
+	for q.items.Len() > 0 {
+		item := heap.Pop(&q.items).(*opportunity)
+		if item.event.CreatedAt.Add(q.ttl).Before(time.Now()) {
+			delete(q.keys, item.key)
+			continue
+		}
+		if q.busy != nil && q.busy(item.event.Base+"-"+item.event.Quote) {
+			skipped = append(skipped, item)
+			continue
+		}
+		delete(q.keys, item.key)
+		return item.event
+	}
+	return nil
+}
+
+func (q *OpportunityQueue) Pop(ctx context.Context) (*ProfitEvent, error) {
+	for {
+		if event := q.next(); event != nil {
+			return event, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+//预期净收益，quote不是USDT时按ocean.one上的价格折算，便于不同交易对之间比较
+func (ant *Ant) expectedProfit(event *ProfitEvent) decimal.Decimal {
+	value := NetProfit(event.Profit).Mul(event.Amount).Mul(event.Price)
+	if event.Quote == USDT {
+		return value
+	}
+	if book, ok := ant.books[event.Quote+"-"+USDT]; ok {
+		if depth := book.GetDepth(1); len(depth.Bids) > 0 {
+			return value.Mul(depth.Bids[0].Price)
+		}
+	}
+	return value
+}
+
+//交易对上由队列发起的在途订单是否已达上限
+func (ant *Ant) busy(pair string) bool {
+	count := 0
+	for _, p := range ant.registry.List() {
+		event := p.event
+		if event.Base+"-"+event.Quote != pair {
+			continue
+		}
+		if event.Strategy == StrategyArbitrage || event.Strategy == StrategyFishing {
+			count += 1
+		}
+	}
+	return count >= PairExecutionLimit
+}
@@ -0,0 +1,205 @@
+package ant
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//结束的订单保留多久，防止同一个机会被重复下单
+	RegistryFinishedTTL = 1 * time.Hour
+)
+
+//所有在途订单，按ProfitEvent id、ocean trace和exin trace索引；
+//小写方法需要在持有锁时调用，position的字段也由这把锁保护
+type Registry struct {
+	sync.Mutex
+	events map[string]*position
+	oceans map[string]*position
+	exins  map[string]*position
+	//余数对冲单的trace分摊到的各个position
+	residuals map[string][]residualShare
+	finished  map[string]time.Time
+	//按结束时间排列，过期时从头部清理finished
+	expiries []expiry
+	//退出时关闭，不再接受新订单，只接受平仓这类处理已有资金的订单
+	closed bool
+}
+
+type expiry struct {
+	id string
+	at time.Time
+}
+
+type residualShare struct {
+	p      *position
+	amount decimal.Decimal
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		events:    make(map[string]*position, 0),
+		oceans:    make(map[string]*position, 0),
+		exins:     make(map[string]*position, 0),
+		residuals: make(map[string][]residualShare, 0),
+		finished:  make(map[string]time.Time, 0),
+	}
+}
+
+func (r *Registry) add(p *position) bool {
+	if r.closed && !p.settlement {
+		return false
+	}
+	if _, ok := r.events[p.event.ID]; ok {
+		return false
+	}
+	if _, ok := r.finished[p.event.ID]; ok {
+		return false
+	}
+	r.events[p.event.ID] = p
+	r.oceans[p.event.ExchangeOrder] = p
+	for _, trace := range p.hedges {
+		r.exins[trace] = p
+	}
+	return true
+}
+
+func (r *Registry) remove(p *position) {
+	delete(r.events, p.event.ID)
+	delete(r.oceans, p.event.ExchangeOrder)
+	for _, trace := range p.hedges {
+		delete(r.exins, trace)
+	}
+	r.finish(p.event.ID)
+}
+
+//记录已结束的event，顺便清理过期的记录
+func (r *Registry) finish(id string) {
+	now := time.Now()
+	r.finished[id] = now
+	r.expiries = append(r.expiries, expiry{id: id, at: now})
+	for len(r.expiries) > 0 && r.expiries[0].at.Add(RegistryFinishedTTL).Before(now) {
+		e := r.expiries[0]
+		r.expiries = r.expiries[1:]
+		//再次结束的event以最后一次为准
+		if r.finished[e.id].Equal(e.at) {
+			delete(r.finished, e.id)
+		}
+	}
+}
+
+func (r *Registry) addExin(p *position, trace string) {
+	p.hedges = append(p.hedges, trace)
+	r.exins[trace] = p
+}
+
+func (r *Registry) removeExin(p *position, trace string) {
+	for i, hedge := range p.hedges {
+		if hedge == trace {
+			p.hedges = append(p.hedges[:i], p.hedges[i+1:]...)
+			break
+		}
+	}
+	delete(r.exins, trace)
+}
+
+//失败重试时用同一个trace，按最后一次的分摊为准
+func (r *Registry) addResidual(trace string, shares []hedgeShare, positions map[string]*position) {
+	parts := make([]residualShare, 0, len(shares))
+	for _, share := range shares {
+		if p, ok := positions[share.EventId]; ok {
+			parts = append(parts, residualShare{p: p, amount: share.Amount})
+		}
+	}
+	r.residuals[trace] = parts
+}
+
+func (r *Registry) removeResidual(trace string) {
+	delete(r.residuals, trace)
+}
+
+//返回的trace和分摊到的position
+func (r *Registry) byResidual(traces ...string) (string, []residualShare, bool) {
+	for _, trace := range traces {
+		if shares, ok := r.residuals[trace]; ok {
+			return trace, shares, true
+		}
+	}
+	return "", nil, false
+}
+
+func (r *Registry) byOcean(traces ...string) (*position, bool) {
+	for _, trace := range traces {
+		if p, ok := r.oceans[trace]; ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) byExin(traces ...string) (*position, bool) {
+	for _, trace := range traces {
+		if p, ok := r.exins[trace]; ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) Add(p *position) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.add(p)
+}
+
+func (r *Registry) Remove(p *position) {
+	r.Lock()
+	defer r.Unlock()
+	r.remove(p)
+}
+
+func (r *Registry) Close() {
+	r.Lock()
+	defer r.Unlock()
+	r.closed = true
+}
+
+//同一个机会已在途或刚结束
+func (r *Registry) Seen(id string) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, inflight := r.events[id]
+	_, finished := r.finished[id]
+	return inflight || finished
+}
+
+func (r *Registry) Finish(id string) {
+	r.Lock()
+	defer r.Unlock()
+	r.finish(id)
+}
+
+func (r *Registry) Event(id string) (*position, bool) {
+	r.Lock()
+	defer r.Unlock()
+	p, ok := r.events[id]
+	return p, ok
+}
+
+func (r *Registry) List() []*position {
+	r.Lock()
+	defer r.Unlock()
+	positions := make([]*position, 0, len(r.events))
+	for _, p := range r.events {
+		positions = append(positions, p)
+	}
+	return positions
+}
+
+func (r *Registry) Len() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.events)
+}
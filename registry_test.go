@@ -0,0 +1,72 @@
+package ant
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//只挂一档买单的盘口
+func testBook(ant *Ant, base, quote string, price, amount decimal.Decimal) {
+	book := ant.OnOrderMessage(base, quote)
+	book.bids.Put(price, &Entry{Side: PageSideBid, Price: price, Amount: amount})
+}
+
+func TestRegistryClose(t *testing.T) {
+	r := NewRegistry()
+	r.Close()
+	event := &ProfitEvent{ID: UuidWithString("open"), ExchangeOrder: UuidWithString("open" + OceanCore)}
+	if r.Add(newPosition(event, true)) {
+		t.Fatal("closed registry accepts a new order")
+	}
+	p := newPosition(&ProfitEvent{ID: UuidWithString("unwind"), ExchangeOrder: UuidWithString("unwind" + OceanCore)}, false)
+	p.settlement = true
+	if !r.Add(p) {
+		t.Fatal("closed registry refuses a settlement order")
+	}
+}
+
+func TestTriangleUnwindAfterClose(t *testing.T) {
+	ctx := SetStore(context.Background(), NewMemoryStore())
+	ant := NewAnt(false, false)
+	testBook(ant, BTC, USDT, dec("10000"), dec("1"))
+	ant.registry.Close()
+
+	//退出过程中，已经换出来的中间资产仍要换回，下单前会登记到registry
+	ant.unwind(ctx, "cycle", 4, BTC, USDT, dec("0.01"))
+	if !ant.registry.Seen(UuidWithString("cycle" + strconv.Itoa(4))) {
+		t.Fatal("unwind refused after the registry is closed")
+	}
+
+	//新的机会不再下单
+	leg := Leg{Side: PageSideAsk, Base: BTC, Quote: USDT}
+	event := newLegEvent("next", 0, StrategyTriangle, leg, dec("10000"), dec("0.01"), decimal.Zero)
+	ant.runLeg(ctx, event, dec("0.01"), false)
+	if ant.registry.Seen(event.ID) {
+		t.Fatal("new leg placed after the registry is closed")
+	}
+}
+
+//过期的记录从队列头部清理，再次结束的event以最后一次为准
+func TestRegistryFinishExpires(t *testing.T) {
+	r := NewRegistry()
+	old := time.Now().Add(-2 * RegistryFinishedTTL)
+	for _, id := range []string{"expired", "again"} {
+		r.finished[id] = old
+		r.expiries = append(r.expiries, expiry{id: id, at: old})
+	}
+	r.Finish("again")
+	r.Finish("new")
+	if r.Seen("expired") {
+		t.Fatal("expired event still seen")
+	}
+	if !r.Seen("again") || !r.Seen("new") {
+		t.Fatal("recently finished events dropped")
+	}
+	if len(r.expiries) != 2 {
+		t.Fatalf("%d expiries queued, want 2", len(r.expiries))
+	}
+}
@@ -0,0 +1,161 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	bot "github.com/MixinNetwork/bot-api-go-client"
+)
+
+//命令的权限等级，用户的等级不低于命令的等级才能执行
+const (
+	PermissionUser  = 0
+	PermissionAdmin = 10
+)
+
+//一条聊天消息，Args是命令名之后按空白拆开的参数
+type Request struct {
+	View       bot.MessageView
+	Text       string
+	Command    string
+	Args       []string
+	Permission int
+}
+
+//聊天命令，MaxArgs小于0时不限制参数个数
+type Command struct {
+	Name       string
+	Aliases    []string
+	Usage      string
+	Help       string
+	Permission int
+	MinArgs    int
+	MaxArgs    int
+	Handler    func(ctx context.Context, req *Request) error
+}
+
+//输入不合法，已经回复过用户；管理命令的Rejection记入审计，Dispatch不再把它作为错误返回
+type Rejection struct {
+	Reason string
+}
+
+func (e *Rejection) Error() string {
+	return e.Reason
+}
+
+type Router struct {
+	commands map[string]*Command
+	names    map[string]*Command
+	//不是命令的消息交给fallback
+	fallback func(ctx context.Context, req *Request) error
+	reply    func(ctx context.Context, req *Request, text string) error
+	//管理命令执行后、被拒绝的输入和无权限的尝试都交给audit
+	audit func(ctx context.Context, req *Request, err error)
+}
+
+func NewRouter(reply func(ctx context.Context, req *Request, text string) error) *Router {
+	return &Router{
+		commands: make(map[string]*Command, 0),
+		names:    make(map[string]*Command, 0),
+		reply:    reply,
+	}
+}
+
+//注册命令，名字或别名重复是编程错误，直接panic
+func (r *Router) Register(c *Command) {
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		name = strings.ToLower(name)
+		if _, ok := r.names[name]; ok {
+			panic(fmt.Sprintf("command %s registered twice", name))
+		}
+		r.names[name] = c
+	}
+	r.commands[c.Name] = c
+}
+
+//把text回复给用户，并返回Rejection
+func (r *Router) Reject(ctx context.Context, req *Request, text string) error {
+	if err := r.reply(ctx, req, text); err != nil {
+		return err
+	}
+	return &Rejection{Reason: text}
+}
+
+func (r *Router) Audit(handler func(ctx context.Context, req *Request, err error)) {
+	r.audit = handler
+}
+
+func (r *Router) Fallback(handler func(ctx context.Context, req *Request) error) {
+	r.fallback = handler
+}
+
+//没有权限的命令当作普通聊天，不暴露命令的存在
+func (r *Router) Dispatch(ctx context.Context, req *Request) error {
+	fields := strings.Fields(req.Text)
+	if len(fields) > 0 {
+		c, ok := r.names[strings.ToLower(fields[0])]
+		if ok && req.Permission >= c.Permission {
+			req.Command, req.Args = c.Name, fields[1:]
+			var err error
+			if len(req.Args) < c.MinArgs || (c.MaxArgs >= 0 && len(req.Args) > c.MaxArgs) {
+				err = r.Reject(ctx, req, "Usage: "+c.usage())
+			} else {
+				err = c.Handler(ctx, req)
+			}
+			if c.Permission >= PermissionAdmin && r.audit != nil {
+				r.audit(ctx, req, err)
+			}
+			if _, rejected := err.(*Rejection); rejected {
+				return nil
+			}
+			return err
+		}
+		if ok && c.Permission >= PermissionAdmin && r.audit != nil {
+			denied := &Request{View: req.View, Text: req.Text, Command: c.Name, Args: fields[1:], Permission: req.Permission}
+			r.audit(ctx, denied, fmt.Errorf("permission denied"))
+		}
+	}
+	if r.fallback != nil {
+		return r.fallback(ctx, req)
+	}
+	return nil
+}
+
+func (c *Command) usage() string {
+	if c.Usage == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Usage
+}
+
+//按权限生成的帮助
+func (r *Router) Help(permission int) string {
+	commands := make([]*Command, 0, len(r.commands))
+	for _, c := range r.commands {
+		if permission >= c.Permission {
+			commands = append(commands, c)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+
+	lines := make([]string, 0, len(commands))
+	for _, c := range commands {
+		line := c.usage() + "  " + c.Help
+		if len(c.Aliases) > 0 {
+			line += " (" + strings.Join(c.Aliases, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+//单个命令的帮助
+func (r *Router) HelpOf(name string, permission int) (string, bool) {
+	c, ok := r.names[strings.ToLower(name)]
+	if !ok || permission < c.Permission {
+		return "", false
+	}
+	return fmt.Sprintf("%s\n%s", c.usage(), c.Help), true
+}
@@ -0,0 +1,94 @@
+package ant
+
+import (
+	"context"
+	"testing"
+)
+
+//被拒绝的输入回复给用户并记入审计，但不作为错误返回给消息循环
+func TestRouterAuditsRejections(t *testing.T) {
+	replies, audits := make([]string, 0), make(map[string]string, 0)
+	r := NewRouter(func(ctx context.Context, req *Request, text string) error {
+		replies = append(replies, text)
+		return nil
+	})
+	r.Register(&Command{
+		Name:       "pause",
+		Usage:      "<pair>",
+		Permission: PermissionAdmin,
+		MinArgs:    1,
+		MaxArgs:    1,
+		Handler: func(ctx context.Context, req *Request) error {
+			if _, _, err := ParsePair(req.Args[0]); err != nil {
+				return r.Reject(ctx, req, err.Error())
+			}
+			return nil
+		},
+	})
+	r.Audit(func(ctx context.Context, req *Request, err error) {
+		audits[req.Text] = "ok"
+		if err != nil {
+			audits[req.Text] = err.Error()
+		}
+	})
+	fallback := 0
+	r.Fallback(func(ctx context.Context, req *Request) error {
+		fallback += 1
+		return nil
+	})
+
+	ctx := context.Background()
+	for _, text := range []string{"pause XXX/USDT", "pause", "pause BTC/USDT"} {
+		if err := r.Dispatch(ctx, &Request{Text: text, Permission: PermissionAdmin}); err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+	}
+	if audits["pause XXX/USDT"] != "unknown symbol XXX" {
+		t.Fatalf("invalid pair audited as %q", audits["pause XXX/USDT"])
+	}
+	if audits["pause"] != "Usage: pause <pair>" {
+		t.Fatalf("usage audited as %q", audits["pause"])
+	}
+	if audits["pause BTC/USDT"] != "ok" {
+		t.Fatalf("valid pause audited as %q", audits["pause BTC/USDT"])
+	}
+	if len(replies) != 2 {
+		t.Fatalf("replies %v", replies)
+	}
+
+	if err := r.Dispatch(ctx, &Request{Text: "pause ETH/BTC", Permission: PermissionUser}); err != nil {
+		t.Fatal(err)
+	}
+	if audits["pause ETH/BTC"] != "permission denied" || fallback != 1 {
+		t.Fatalf("denied pause audited as %q, fallback %d", audits["pause ETH/BTC"], fallback)
+	}
+}
+
+//暂停的交易对和阈值写入checkpoint，重启后恢复
+func TestControlsPersist(t *testing.T) {
+	ctx := SetStore(context.Background(), NewMemoryStore())
+	ant := NewAnt(false, false)
+	if err := ant.setPairPaused(ctx, BTC, USDT, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ant.setPairPaused(ctx, ETH, BTC, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ant.setPairPaused(ctx, ETH, BTC, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ant.setThreshold(ctx, dec("0.012")); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewAnt(false, false)
+	if err := restarted.LoadControls(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.pairPaused(BTC, USDT) || restarted.pairPaused(ETH, BTC) {
+		t.Fatalf("paused pairs %v", restarted.pausedPairs())
+	}
+	if !restarted.threshold().Equal(dec("0.012")) {
+		t.Fatalf("threshold %s", restarted.threshold())
+	}
+}
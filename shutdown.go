@@ -0,0 +1,67 @@
+package ant
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	//退出时等待退款和对冲的最长时间
+	ShutdownTimeout = 60 * time.Second
+)
+
+//分阶段退出：停止下新单，撤掉所有挂单，等待退款和成交的snapshot并对冲，最后把订单的实际数量写入数据库。
+//调用前应先停止各个策略，但snapshot的轮询需要继续运行
+func (ant *Ant) Shutdown(ctx context.Context, timeout time.Duration) {
+	defer ant.spreads.Flush(ctx, true)
+	ant.registry.Close()
+	log.Printf("shutting down, %d orders in flight", ant.registry.Len())
+
+	ant.cancelAll()
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for ant.registry.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Println("shutdown interrupted", ctx.Err())
+			return
+		case <-deadline:
+			log.Printf("shutdown timeout, %d orders not settled", ant.registry.Len())
+			ant.settleAll(ctx)
+			return
+		case <-ticker.C:
+			for _, p := range ant.registry.List() {
+				ant.hedge(ctx, p)
+				ant.fallback(ctx, p)
+				ant.reconcile(ctx, p, false)
+			}
+		}
+	}
+	ant.flushResiduals(ctx)
+	log.Println("all orders settled")
+}
+
+//超时后对冲已知的成交，按已收到的snapshot写入数据库
+func (ant *Ant) settleAll(ctx context.Context) {
+	for _, p := range ant.registry.List() {
+		ant.hedge(ctx, p)
+		ant.registry.Lock()
+		event := p.event
+		log.Printf("unsettled %s %s %s/%s, base: %v, quote: %v", event.Strategy, event.Category, Who(event.Base), Who(event.Quote), event.BaseAmount, event.QuoteAmount)
+		ant.registry.Unlock()
+		if p.otc {
+			ant.reconcile(ctx, p, true)
+			continue
+		}
+		ant.registry.Lock()
+		updates := map[string]interface{}{"base_amount": event.BaseAmount, "quote_amount": event.QuoteAmount}
+		ant.registry.Unlock()
+		if err := Storage(ctx).UpdateEvent(event.ID, updates); err != nil {
+			log.Println("update event error", err)
+		}
+	}
+	ant.flushResiduals(ctx)
+}
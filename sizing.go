@@ -0,0 +1,111 @@
+package ant
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//mixin转账金额的精度
+	TransferPrecision = 8
+)
+
+//ocean.one订单实际的下单参数，Send为转给ocean.one的数量：买单是quote，卖单是base
+type OrderSize struct {
+	Side   string
+	Price  decimal.Decimal
+	Amount decimal.Decimal
+	Funds  decimal.Decimal
+	Send   decimal.Decimal
+}
+
+func roundUp(d decimal.Decimal, places int32) decimal.Decimal {
+	truncated := d.Truncate(places)
+	if truncated.LessThan(d) {
+		truncated = truncated.Add(decimal.New(1, -places))
+	}
+	return truncated
+}
+
+//买单价格向下取整，卖单价格向上取整，保证不会比预期成交得更差
+func roundPrice(side string, price decimal.Decimal) decimal.Decimal {
+	if side == PageSideBid {
+		return price.Truncate(PricePrecision)
+	}
+	return roundUp(price, PricePrecision)
+}
+
+//买入amount个base(扣除taker手续费后)需要的quote，以及卖出amount个base；超出余额时按余额下单
+func SizeOrder(side string, price, amount, balance decimal.Decimal) (*OrderSize, error) {
+	price = roundPrice(side, price)
+	if !price.IsPositive() {
+		return nil, fmt.Errorf("invalid price %v", price)
+	}
+
+	if side == PageSideBid {
+		funds := roundUp(amount.Mul(price).Div(decimal.NewFromFloat(1-OceanFee)), TransferPrecision)
+		if funds.GreaterThan(balance) {
+			funds = balance.Truncate(TransferPrecision)
+		}
+		return SizeSend(side, price, funds)
+	}
+
+	if amount.GreaterThan(balance) {
+		amount = balance
+	}
+	return SizeSend(side, price, amount)
+}
+
+//按转给ocean.one的数量计算订单，买单send是quote，卖单send是base
+func SizeSend(side string, price, send decimal.Decimal) (*OrderSize, error) {
+	price = roundPrice(side, price)
+	if !price.IsPositive() {
+		return nil, fmt.Errorf("invalid price %v", price)
+	}
+
+	fee := decimal.NewFromFloat(1 - OceanFee)
+	size := &OrderSize{Side: side, Price: price}
+	switch side {
+	case PageSideBid:
+		size.Funds = send.Truncate(TransferPrecision)
+		size.Amount = size.Funds.Div(price).Mul(fee).Truncate(AmountPrecision)
+		size.Send = size.Funds
+	case PageSideAsk:
+		size.Amount = send.Truncate(AmountPrecision)
+		size.Funds = size.Amount.Mul(price).Mul(fee).Truncate(TransferPrecision)
+		size.Send = size.Amount
+	default:
+		return nil, fmt.Errorf("wrong side %v", side)
+	}
+
+	if err := size.Validate(); err != nil {
+		return nil, err
+	}
+	return size, nil
+}
+
+//按限价全部成交时得到的数量：买单得到Amount，卖单得到Funds
+func (size *OrderSize) Receive() decimal.Decimal {
+	if size.Side == PageSideBid {
+		return size.Amount
+	}
+	return size.Funds
+}
+
+//超出ocean.one限制的订单会被直接退回
+func (size *OrderSize) Validate() error {
+	if size.Price.GreaterThan(decimal.New(MaxPrice, 0)) {
+		return fmt.Errorf("price %v exceeds max price %v", size.Price, MaxPrice)
+	}
+	if !size.Amount.IsPositive() || !size.Send.IsPositive() {
+		return fmt.Errorf("%s order too small, price %v, send %v", size.Side, size.Price, size.Send)
+	}
+	if size.Amount.GreaterThan(decimal.New(MaxAmount, 0)) {
+		return fmt.Errorf("amount %v exceeds max amount %v", size.Amount, MaxAmount)
+	}
+	if size.Funds.GreaterThan(decimal.New(MaxFunds, 0)) {
+		return fmt.Errorf("funds %v exceeds max funds %v", size.Funds, int64(MaxFunds))
+	}
+	return nil
+}
@@ -29,6 +29,15 @@ type Snapshot struct {
 	Data       string    `json:"data"             gorm:"type:varchar(255)"`
 	CreatedAt  time.Time `json:"created_at"       gorm:"type:timestamp"`
 	Asset      `json:"asset"            gorm:"type:varchar(36)"`
+	//memo解码后的内容
+	Venue      string `json:"venue"            gorm:"type:varchar(8);index"`
+	ReplyType  string `json:"reply_type"       gorm:"type:varchar(16);index"`
+	OrderId    string `json:"order_id"         gorm:"type:varchar(36);index"`
+	AskOrderId string `json:"ask_order_id"     gorm:"type:varchar(36);index"`
+	BidOrderId string `json:"bid_order_id"     gorm:"type:varchar(36);index"`
+	Price      string `json:"price"            gorm:"type:varchar(36)"`
+	Fee        string `json:"fee"              gorm:"type:varchar(36)"`
+	FeeAsset   string `json:"fee_asset"        gorm:"type:varchar(36)"`
 }
 
 func (Snapshot) TableName() string {
@@ -59,38 +68,139 @@ func (ex *Ant) requestMixinNetwork(ctx context.Context, checkpoint time.Time, li
 	return resp.Data, nil
 }
 
+//已处理过的snapshot，只保留不早于checkpoint的，checkpoint之前的不会再被拉取
+type snapshotDedupe struct {
+	seen map[string]time.Time
+}
+
+func newSnapshotDedupe() *snapshotDedupe {
+	return &snapshotDedupe{seen: make(map[string]time.Time, 0)}
+}
+
+func (d *snapshotDedupe) Seen(id string) bool {
+	_, ok := d.seen[id]
+	return ok
+}
+
+func (d *snapshotDedupe) Add(id string, at time.Time) {
+	d.seen[id] = at
+}
+
+func (d *snapshotDedupe) Prune(checkpoint time.Time) {
+	for id, at := range d.seen {
+		if at.Before(checkpoint) {
+			delete(d.seen, id)
+		}
+	}
+}
+
+//上次处理到的位置，没有记录时从现在开始
+func (ex *Ant) readCheckpoint(ctx context.Context) time.Time {
+	value, err := Storage(ctx).ReadCheckpoint(CheckpointMixinNetworkSnapshots)
+	if err != nil {
+		log.Println("read checkpoint error", err)
+	}
+	if value == "" {
+		return time.Now().UTC()
+	}
+	checkpoint, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		log.Println("parse checkpoint error", err)
+		return time.Now().UTC()
+	}
+	return checkpoint
+}
+
+func (ex *Ant) writeCheckpoint(ctx context.Context, checkpoint time.Time) {
+	if err := Storage(ctx).WriteCheckpoint(CheckpointMixinNetworkSnapshots, checkpoint.Format(time.RFC3339Nano)); err != nil {
+		log.Println("write checkpoint error", err)
+	}
+}
+
+//查数据库判断snapshot是否已经处理过
+func (ex *Ant) snapshotStored(ctx context.Context, id string) bool {
+	exists, err := Storage(ctx).SnapshotExists(id)
+	if err != nil {
+		log.Println("check snapshot error", err)
+	}
+	return exists
+}
+
 func (ex *Ant) PollMixinNetwork(ctx context.Context) {
 	const limit = 500
-	checkpoint := time.Now().UTC()
+	checkpoint := ex.readCheckpoint(ctx)
+	//重启后的第一批可能在退出前已经处理了一部分
+	resumed := true
+	log.Println("poll mixin network from", checkpoint)
 	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		requestedAt := time.Now()
 		snapshots, err := ex.requestMixinNetwork(ctx, checkpoint, limit)
 		if err != nil {
 			log.Println("PollMixinNetwork ERROR", err)
-			time.Sleep(PollInterval)
+			sleep(ctx, PollInterval)
 			continue
 		}
-		for _, s := range snapshots {
-			if ex.snapshots[s.SnapshotId] {
-				continue
-			}
-			ex.ensureProcessSnapshot(ctx, s)
-			checkpoint = s.CreatedAt
-			ex.snapshots[s.SnapshotId] = true
+		checkpoint = ex.processPage(ctx, snapshots, checkpoint, resumed)
+		if len(snapshots) > 0 {
+			ex.writeCheckpoint(ctx, checkpoint)
+			ex.snapshots.Prune(checkpoint)
+			resumed = false
 		}
 		if len(snapshots) < limit {
-			time.Sleep(PollInterval)
+			ex.balances.Synced(requestedAt)
+			sleep(ctx, PollInterval)
+		}
+	}
+}
+
+//等待d，ctx结束时提前返回
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+//处理一页snapshot，返回新的checkpoint；跳过的snapshot也要推进checkpoint，
+//否则整页都已处理过时会一直请求同一个位置
+func (ex *Ant) processPage(ctx context.Context, snapshots []*Snapshot, checkpoint time.Time, resumed bool) time.Time {
+	for _, s := range snapshots {
+		if ex.snapshots.Seen(s.SnapshotId) {
+			checkpoint = s.CreatedAt
+			continue
+		}
+		if resumed && ex.snapshotStored(ctx, s.SnapshotId) {
+			ex.snapshots.Add(s.SnapshotId, s.CreatedAt)
+			checkpoint = s.CreatedAt
+			continue
 		}
+		//退出时没处理完的snapshot留到下次从checkpoint开始处理
+		if ex.ensureProcessSnapshot(ctx, s) != nil {
+			return checkpoint
+		}
+		ex.balances.Apply(s)
+		checkpoint = s.CreatedAt
+		ex.snapshots.Add(s.SnapshotId, s.CreatedAt)
 	}
+	return checkpoint
 }
 
-func (ex *Ant) ensureProcessSnapshot(ctx context.Context, s *Snapshot) {
+func (ex *Ant) ensureProcessSnapshot(ctx context.Context, s *Snapshot) error {
 	for {
 		err := ex.processSnapshot(ctx, s)
 		if err == nil {
-			break
+			return nil
 		}
 		log.Println("ensureProcessSnapshot", err)
-		time.Sleep(100 * time.Millisecond)
+		sleep(ctx, 100*time.Millisecond)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 	}
 }
 
@@ -99,14 +209,21 @@ func (ex *Ant) processSnapshot(ctx context.Context, s *Snapshot) error {
 		return nil
 	}
 
+	if err := s.Decode(); err != nil {
+		log.Println("decode snapshot error", s.SnapshotId, err)
+	}
+
 	if err := ex.HandleSnapshot(ctx, s); err != nil {
 		log.Println(err)
 		return err
 	}
 
-	if err := Database(ctx).FirstOrCreate(s).Error; err != nil {
+	if err := Storage(ctx).SaveSnapshot(s); err != nil {
 		return err
 	}
 
+	if err := ex.RecordLedger(ctx, s); err != nil {
+		log.Println("record ledger error", err)
+	}
 	return nil
 }
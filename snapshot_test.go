@@ -0,0 +1,136 @@
+package ant
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testPage(start time.Time, n int) []*Snapshot {
+	snapshots := make([]*Snapshot, 0, n)
+	for i := 0; i < n; i++ {
+		snapshots = append(snapshots, &Snapshot{
+			SnapshotId: UuidWithString("snapshot" + strconv.Itoa(i)),
+			Amount:     "1",
+			CreatedAt:  start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	return snapshots
+}
+
+func TestProcessPageTwice(t *testing.T) {
+	ctx := SetStore(context.Background(), NewMemoryStore())
+	start := time.Now().Add(-time.Hour).UTC()
+	page := testPage(start, 5)
+	last := page[len(page)-1].CreatedAt
+
+	ant := NewAnt(false, false)
+	checkpoint := ant.processPage(ctx, page, start, false)
+	if !checkpoint.Equal(last) {
+		t.Fatalf("first pass checkpoint %v, want %v", checkpoint, last)
+	}
+	//同一页再来一次，都已处理过，checkpoint仍然要推进
+	checkpoint = ant.processPage(ctx, page, start, false)
+	if !checkpoint.Equal(last) {
+		t.Fatalf("second pass checkpoint %v, want %v", checkpoint, last)
+	}
+}
+
+func TestProcessPageResumed(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := SetStore(context.Background(), store)
+	start := time.Now().Add(-time.Hour).UTC()
+	page := testPage(start, 5)
+	//上次退出前已经存下整页，但没来得及写checkpoint
+	for _, s := range page {
+		if err := store.SaveSnapshot(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ant := NewAnt(false, false)
+	checkpoint := ant.processPage(ctx, page, start, true)
+	if last := page[len(page)-1].CreatedAt; !checkpoint.Equal(last) {
+		t.Fatalf("resumed checkpoint %v, want %v", checkpoint, last)
+	}
+	for _, s := range page {
+		if !ant.snapshots.Seen(s.SnapshotId) {
+			t.Fatalf("snapshot %s not marked as seen", s.SnapshotId)
+		}
+	}
+}
+
+//重启后补拉的snapshot要匹配到重启前的event，成交照常记账和对冲
+func TestHandleSnapshotAfterRestart(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := SetStore(context.Background(), store)
+	event := &ProfitEvent{
+		ID:            UuidWithString("before restart"),
+		Category:      PageSideBid,
+		Strategy:      StrategyArbitrage,
+		Price:         dec("10000"),
+		Amount:        dec("0.01"),
+		Min:           dec("1"),
+		Max:           dec("10"),
+		Base:          BTC,
+		Quote:         USDT,
+		BaseAmount:    dec("0"),
+		QuoteAmount:   dec("-100"),
+		ExchangeOrder: UuidWithString("before restart" + OceanCore),
+		CreatedAt:     time.Now().Add(-time.Minute),
+	}
+	if err := store.SaveEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	hedge := &HedgeOrder{ID: UuidWithString("hedge"), TraceId: UuidWithString("hedge trace"), EventId: event.ID, Amount: dec("0.005"), CreatedAt: time.Now()}
+	if err := store.SaveHedgeOrder(hedge); err != nil {
+		t.Fatal(err)
+	}
+
+	ant := NewAnt(false, false)
+	fill := &Snapshot{SnapshotId: UuidWithString("fill"), Amount: "0.01", OpponentId: OceanCore, ReplyType: ReplyMatch, BidOrderId: event.ExchangeOrder}
+	fill.AssetId = BTC
+	if err := ant.HandleSnapshot(ctx, fill); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := ant.registry.Event(event.ID)
+	if !ok || !p.otc {
+		t.Fatal("event placed before the restart not restored")
+	}
+	//不足exin最小交易量，等待下次成交一起对冲
+	ant.registry.Lock()
+	base, filled := p.event.BaseAmount, p.filled
+	ant.registry.Unlock()
+	if !base.Equal(dec("0.01")) || !filled.Equal(dec("0.01")) {
+		t.Fatalf("fill not credited: base %v, filled %v", base, filled)
+	}
+
+	//对冲单在重启前发出，exin的返回也要记到event上
+	returned := &Snapshot{SnapshotId: UuidWithString("return"), Amount: "50", OpponentId: ExinCore, TraceId: hedge.TraceId}
+	returned.AssetId = USDT
+	if err := ant.HandleSnapshot(ctx, returned); err != nil {
+		t.Fatal(err)
+	}
+	ant.registry.Lock()
+	quote := p.event.QuoteAmount
+	ant.registry.Unlock()
+	if !quote.Equal(dec("-50")) {
+		t.Fatalf("exin return not credited: quote %v", quote)
+	}
+
+	//本次运行中已经结束的event只把金额记到数据库
+	ant.reconcile(ctx, p, true)
+	late := &Snapshot{SnapshotId: UuidWithString("late"), Amount: "1", OpponentId: OceanCore, ReplyType: ReplyRefund, BidOrderId: event.ExchangeOrder}
+	late.AssetId = USDT
+	if err := ant.HandleSnapshot(ctx, late); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ant.registry.Event(event.ID); ok {
+		t.Fatal("finished event restored again")
+	}
+	stored, err := store.FindEvent(event.ID)
+	if err != nil || stored == nil || !stored.QuoteAmount.Equal(dec("-49")) {
+		t.Fatalf("late refund not credited: %v %v", stored, err)
+	}
+}
@@ -0,0 +1,355 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	SpreadRaw    = "raw"
+	SpreadMinute = "1m"
+	SpreadHour   = "1h"
+)
+
+//原始价差的采样间隔
+var SpreadInterval = 10 * time.Second
+
+//各精度价差的保留时间，1h的一直保留
+var SpreadRetention = map[string]time.Duration{
+	SpreadRaw:    24 * time.Hour,
+	SpreadMinute: 7 * 24 * time.Hour,
+}
+
+//两边的最优价格和价差，和Inspect的算法一致：
+//BidSpread是在ocean.one卖给买一、在exin买入的收益率，AskSpread是在ocean.one买入卖一、在exin卖出的收益率；
+//1m和1h是这段时间内的平均值和最大值，价格取最后一次采样
+type SpreadSample struct {
+	ID           string          `json:"id"               gorm:"type:varchar(36);primary_key"`
+	Base         string          `json:"base"             gorm:"type:varchar(36);index:idx_ant_spreads_pair"`
+	Quote        string          `json:"quote"            gorm:"type:varchar(36);index:idx_ant_spreads_pair"`
+	Resolution   string          `json:"resolution"       gorm:"type:varchar(4);index:idx_ant_spreads_pair"`
+	OceanBid     decimal.Decimal `json:"ocean_bid"        gorm:"type:varchar(36)"`
+	OceanAsk     decimal.Decimal `json:"ocean_ask"        gorm:"type:varchar(36)"`
+	ExinBid      decimal.Decimal `json:"exin_bid"         gorm:"type:varchar(36)"`
+	ExinAsk      decimal.Decimal `json:"exin_ask"         gorm:"type:varchar(36)"`
+	BidSpread    decimal.Decimal `json:"bid_spread"       gorm:"type:varchar(36)"`
+	AskSpread    decimal.Decimal `json:"ask_spread"       gorm:"type:varchar(36)"`
+	MaxBidSpread decimal.Decimal `json:"max_bid_spread"   gorm:"type:varchar(36)"`
+	MaxAskSpread decimal.Decimal `json:"max_ask_spread"   gorm:"type:varchar(36)"`
+	Samples      int             `json:"samples"`
+	CreatedAt    time.Time       `json:"created_at"       gorm:"index"`
+}
+
+func (SpreadSample) TableName() string {
+	return "ant_spreads"
+}
+
+func (s SpreadSample) spread(side string) decimal.Decimal {
+	if side == PageSideAsk {
+		return s.AskSpread
+	}
+	return s.BidSpread
+}
+
+//时段内的最大价差，原始采样和spread相同
+func (s SpreadSample) maxSpread(side string) decimal.Decimal {
+	if side == PageSideAsk {
+		return s.MaxAskSpread
+	}
+	return s.MaxBidSpread
+}
+
+//正在汇总的一个时间段
+type spreadBucket struct {
+	start  time.Time
+	last   SpreadSample
+	bidSum decimal.Decimal
+	askSum decimal.Decimal
+	bidMax decimal.Decimal
+	askMax decimal.Decimal
+	n      int
+}
+
+func newSpreadBucket(start time.Time) *spreadBucket {
+	return &spreadBucket{start: start, bidSum: decimal.Zero, askSum: decimal.Zero}
+}
+
+//加入一个样本，n是样本本身代表的原始采样数
+func (b *spreadBucket) add(s SpreadSample) {
+	n := decimal.New(int64(s.Samples), 0)
+	if b.n == 0 || s.MaxBidSpread.GreaterThan(b.bidMax) {
+		b.bidMax = s.MaxBidSpread
+	}
+	if b.n == 0 || s.MaxAskSpread.GreaterThan(b.askMax) {
+		b.askMax = s.MaxAskSpread
+	}
+	b.bidSum = b.bidSum.Add(s.BidSpread.Mul(n))
+	b.askSum = b.askSum.Add(s.AskSpread.Mul(n))
+	b.n += s.Samples
+	b.last = s
+}
+
+func (b *spreadBucket) sample(resolution string) SpreadSample {
+	n := decimal.New(int64(b.n), 0)
+	s := b.last
+	s.ID = uuid.Must(uuid.NewV4()).String()
+	s.Resolution = resolution
+	s.BidSpread, s.AskSpread = b.bidSum.Div(n), b.askSum.Div(n)
+	s.MaxBidSpread, s.MaxAskSpread = b.bidMax, b.askMax
+	s.Samples = b.n
+	s.CreatedAt = b.start
+	return s
+}
+
+//按SpreadInterval记录价差，同时汇总成1m和1h
+type SpreadRecorder struct {
+	mutex   sync.Mutex
+	last    map[string]time.Time
+	minutes map[string]*spreadBucket
+	hours   map[string]*spreadBucket
+}
+
+func NewSpreadRecorder() *SpreadRecorder {
+	return &SpreadRecorder{
+		last:    make(map[string]time.Time, 0),
+		minutes: make(map[string]*spreadBucket, 0),
+		hours:   make(map[string]*spreadBucket, 0),
+	}
+}
+
+func (r *SpreadRecorder) Observe(ctx context.Context, base, quote string, ocean, exin *Depth) {
+	if ocean == nil || exin == nil {
+		return
+	}
+	if len(ocean.Bids) == 0 || len(ocean.Asks) == 0 || len(exin.Bids) == 0 || len(exin.Asks) == 0 {
+		return
+	}
+	pair := base + "-" + quote
+	now := time.Now()
+
+	r.mutex.Lock()
+	if now.Sub(r.last[pair]) < SpreadInterval {
+		r.mutex.Unlock()
+		return
+	}
+	r.last[pair] = now
+
+	s := SpreadSample{
+		ID:         uuid.Must(uuid.NewV4()).String(),
+		Base:       base,
+		Quote:      quote,
+		Resolution: SpreadRaw,
+		OceanBid:   ocean.Bids[0].Price,
+		OceanAsk:   ocean.Asks[0].Price,
+		ExinBid:    exin.Bids[0].Price,
+		ExinAsk:    exin.Asks[0].Price,
+		Samples:    1,
+		CreatedAt:  now,
+	}
+	s.BidSpread = s.OceanBid.Sub(s.ExinAsk).Div(s.ExinAsk)
+	s.AskSpread = s.ExinBid.Sub(s.OceanAsk).Div(s.ExinBid)
+	s.MaxBidSpread, s.MaxAskSpread = s.BidSpread, s.AskSpread
+
+	closed, prune := r.roll(pair, now, false)
+	if r.minutes[pair] == nil {
+		r.minutes[pair] = newSpreadBucket(now.Truncate(time.Minute))
+	}
+	r.minutes[pair].add(s)
+	r.mutex.Unlock()
+
+	saveSpreads(Storage(ctx), append([]SpreadSample{s}, closed...), prune, now)
+}
+
+//关闭到now为止已经结束的1m和1h时间段，返回汇总的样本以及是否关闭了1h；
+//force为true时不论是否结束都关闭，用于退出。调用时需要持有mutex
+func (r *SpreadRecorder) roll(pair string, now time.Time, force bool) ([]SpreadSample, bool) {
+	samples := make([]SpreadSample, 0)
+	hourly := false
+	if b := r.minutes[pair]; b != nil && (force || !now.Before(b.start.Add(time.Minute))) {
+		m := b.sample(SpreadMinute)
+		samples = append(samples, m)
+		delete(r.minutes, pair)
+
+		hour := m.CreatedAt.Truncate(time.Hour)
+		if h := r.hours[pair]; h != nil && !h.start.Equal(hour) {
+			samples = append(samples, h.sample(SpreadHour))
+			hourly = true
+			delete(r.hours, pair)
+		}
+		if r.hours[pair] == nil {
+			r.hours[pair] = newSpreadBucket(hour)
+		}
+		r.hours[pair].add(m)
+	}
+	if h := r.hours[pair]; h != nil && (force || !now.Before(h.start.Add(time.Hour))) {
+		samples = append(samples, h.sample(SpreadHour))
+		hourly = true
+		delete(r.hours, pair)
+	}
+	return samples, hourly
+}
+
+//行情中断时没有新的采样，也要按时写入已经结束的时间段；force用于退出时写入没结束的部分
+func (r *SpreadRecorder) Flush(ctx context.Context, force bool) {
+	now := time.Now()
+	samples, prune := make([]SpreadSample, 0), false
+	r.mutex.Lock()
+	pairs := make(map[string]bool, len(r.minutes)+len(r.hours))
+	for pair := range r.minutes {
+		pairs[pair] = true
+	}
+	for pair := range r.hours {
+		pairs[pair] = true
+	}
+	for pair := range pairs {
+		closed, hourly := r.roll(pair, now, force)
+		samples = append(samples, closed...)
+		prune = prune || hourly
+	}
+	r.mutex.Unlock()
+
+	saveSpreads(Storage(ctx), samples, prune, now)
+}
+
+func saveSpreads(store Store, samples []SpreadSample, prune bool, now time.Time) {
+	for _, sample := range samples {
+		if err := store.SaveSpread(&sample); err != nil {
+			log.Println("create spread error", err)
+		}
+	}
+	//每小时清理一次过期的数据
+	if prune {
+		for resolution, retention := range SpreadRetention {
+			if err := store.PruneSpreads(resolution, now.Add(-retention)); err != nil {
+				log.Println("prune spreads error", err)
+			}
+		}
+	}
+}
+
+//按SpreadInterval写入已经结束的1m和1h时间段，退出时由Shutdown写入剩下的
+func (ant *Ant) RecordSpreads(ctx context.Context) {
+	ticker := time.NewTicker(SpreadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ant.spreads.Flush(ctx, false)
+		}
+	}
+}
+
+func querySpreads(ctx context.Context, base, quote, resolution string, from, to time.Time) ([]SpreadSample, error) {
+	db := Database(ctx)
+	if db == nil {
+		return nil, fmt.Errorf("spreads need a sql store")
+	}
+	var samples []SpreadSample
+	err := db.Where("base=? AND quote=? AND resolution=? AND created_at >= ? AND created_at < ?", base, quote, resolution, from, to).Order("created_at").Find(&samples).Error
+	return samples, err
+}
+
+//side方向价差的分位数，ps取0到1
+func SpreadPercentiles(ctx context.Context, base, quote, side, resolution string, from, to time.Time, ps ...float64) ([]decimal.Decimal, error) {
+	samples, err := querySpreads(ctx, base, quote, resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no spreads for %s/%s", Who(base), Who(quote))
+	}
+	spreads := make([]decimal.Decimal, 0, len(samples))
+	for _, s := range samples {
+		spreads = append(spreads, s.spread(side))
+	}
+	sort.Slice(spreads, func(i, j int) bool { return spreads[i].LessThan(spreads[j]) })
+
+	result := make([]decimal.Decimal, 0, len(ps))
+	for _, p := range ps {
+		index := int(p * float64(len(spreads)-1))
+		if index < 0 {
+			index = 0
+		} else if index >= len(spreads) {
+			index = len(spreads) - 1
+		}
+		result = append(result, spreads[index])
+	}
+	return result, nil
+}
+
+//价差超过阈值的情况
+type SpreadExceedance struct {
+	//超过阈值的样本占比
+	Ratio float64
+	//连续超过阈值的次数、平均和最长持续时间
+	Episodes int
+	Average  time.Duration
+	Longest  time.Duration
+}
+
+//side方向的价差超过threshold的频率和持续时间，每个样本代表一个采样周期；
+//1m和1h用时段内的最大价差判断，平均值会抹掉短暂出现的机会
+func SpreadAbove(ctx context.Context, base, quote, side, resolution string, threshold decimal.Decimal, from, to time.Time) (*SpreadExceedance, error) {
+	samples, err := querySpreads(ctx, base, quote, resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+	period := SpreadInterval
+	switch resolution {
+	case SpreadMinute:
+		period = time.Minute
+	case SpreadHour:
+		period = time.Hour
+	}
+
+	result := &SpreadExceedance{}
+	above, total := 0, time.Duration(0)
+	var start time.Time
+	var previous *SpreadSample
+	closeEpisode := func(end time.Time) {
+		d := end.Sub(start) + period
+		total += d
+		if d > result.Longest {
+			result.Longest = d
+		}
+		result.Episodes += 1
+	}
+	for i := range samples {
+		s := &samples[i]
+		if s.maxSpread(side).GreaterThan(threshold) {
+			above += 1
+			//中间缺了采样就算新的一段
+			if previous == nil || s.CreatedAt.Sub(previous.CreatedAt) > 2*period {
+				if previous != nil {
+					closeEpisode(previous.CreatedAt)
+				}
+				start = s.CreatedAt
+			}
+			previous = s
+			continue
+		}
+		if previous != nil {
+			closeEpisode(previous.CreatedAt)
+			previous = nil
+		}
+	}
+	if previous != nil {
+		closeEpisode(previous.CreatedAt)
+	}
+	if len(samples) > 0 {
+		result.Ratio = float64(above) / float64(len(samples))
+	}
+	if result.Episodes > 0 {
+		result.Average = total / time.Duration(result.Episodes)
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package ant
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//1m样本的平均值低于阈值，但时段内的最大值超过阈值
+func TestSpreadAboveUsesMax(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db, -1); err != nil {
+		t.Fatal(err)
+	}
+	ctx := SetDB(context.Background(), db)
+	start := time.Now().UTC().Truncate(time.Minute).Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		s := SpreadSample{
+			ID:           UuidWithString("spread" + start.Add(time.Duration(i)*time.Minute).String()),
+			Base:         BTC,
+			Quote:        USDT,
+			Resolution:   SpreadMinute,
+			BidSpread:    dec("0.001"),
+			AskSpread:    dec("0.001"),
+			MaxBidSpread: dec("0.02"),
+			MaxAskSpread: dec("0.001"),
+			Samples:      6,
+			CreatedAt:    start.Add(time.Duration(i) * time.Minute),
+		}
+		if err := db.Create(&s).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	above, err := SpreadAbove(ctx, BTC, USDT, PageSideBid, SpreadMinute, dec("0.01"), start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if above.Ratio != 1 || above.Episodes != 1 || above.Longest != 3*time.Minute {
+		t.Fatalf("bid above %+v", above)
+	}
+	above, err = SpreadAbove(ctx, BTC, USDT, PageSideAsk, SpreadMinute, dec("0.01"), start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if above.Ratio != 0 || above.Episodes != 0 {
+		t.Fatalf("ask above %+v", above)
+	}
+}
+
+//没有新的采样时，已经结束的时间段也要按时关闭，退出时关闭所有时间段
+func TestSpreadRoll(t *testing.T) {
+	r := NewSpreadRecorder()
+	pair := BTC + "-" + USDT
+	hour := time.Now().Truncate(time.Hour)
+	r.minutes[pair] = newSpreadBucket(hour)
+	r.minutes[pair].add(SpreadSample{BidSpread: dec("0.01"), AskSpread: dec("0.02"), MaxBidSpread: dec("0.01"), MaxAskSpread: dec("0.02"), Samples: 1})
+
+	if samples, _ := r.roll(pair, hour.Add(30*time.Second), false); len(samples) != 0 {
+		t.Fatalf("open minute closed: %v", samples)
+	}
+	samples, hourly := r.roll(pair, hour.Add(time.Minute), false)
+	if len(samples) != 1 || samples[0].Resolution != SpreadMinute || hourly {
+		t.Fatalf("minute not closed: %v %v", samples, hourly)
+	}
+	if r.minutes[pair] != nil || r.hours[pair] == nil {
+		t.Fatal("minute not moved into the hour")
+	}
+	samples, hourly = r.roll(pair, hour.Add(time.Hour), false)
+	if len(samples) != 1 || samples[0].Resolution != SpreadHour || !hourly {
+		t.Fatalf("hour not closed: %v %v", samples, hourly)
+	}
+
+	r.minutes[pair] = newSpreadBucket(hour)
+	r.minutes[pair].add(SpreadSample{Samples: 1})
+	samples, _ = r.roll(pair, hour, true)
+	if len(samples) != 2 || r.minutes[pair] != nil || r.hours[pair] != nil {
+		t.Fatalf("forced roll left buckets open: %v", samples)
+	}
+}
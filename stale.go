@@ -0,0 +1,87 @@
+package ant
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+//行情数据允许的最大延迟，超过后不再产生套利机会
+type Freshness struct {
+	//ocean.one盘口最后一次更新
+	BookAge time.Duration
+	//exin报价获取的时间
+	QuoteAge time.Duration
+	//ocean.one事件时间戳落后本地时钟的程度
+	EventLag time.Duration
+}
+
+var MaxDataAge = Freshness{
+	BookAge:  60 * time.Second,
+	QuoteAge: 5 * time.Second,
+	EventLag: 10 * time.Second,
+}
+
+//各交易对最近一次跳过的原因，原因变化时才打印日志
+type staleReasons struct {
+	sync.Mutex
+	reasons map[string]string
+}
+
+func (r *staleReasons) report(pair, reason string) {
+	r.Lock()
+	defer r.Unlock()
+	if r.reasons[pair] == reason {
+		return
+	}
+	r.reasons[pair] = reason
+	if reason == "" {
+		log.Printf("%s data fresh again", pair)
+	} else {
+		log.Printf("%s skipped, %s", pair, reason)
+	}
+}
+
+func (r *staleReasons) get(pair string) string {
+	r.Lock()
+	defer r.Unlock()
+	return r.reasons[pair]
+}
+
+//检查盘口和exin报价是否足够新，返回不能交易的原因
+func (ant *Ant) checkFresh(base, quote string, otc Order) error {
+	now := time.Now()
+	pair := base + "-" + quote
+	book, ok := ant.books[pair]
+	if !ok {
+		return fmt.Errorf("no ocean book")
+	}
+
+	updatedAt, eventAt, synced := book.Clock()
+	if !synced {
+		return fmt.Errorf("ocean book not synced after reset")
+	}
+	if age := now.Sub(updatedAt); age > MaxDataAge.BookAge {
+		return fmt.Errorf("ocean book is %v old", age.Round(time.Second))
+	}
+	lag := updatedAt.Sub(eventAt)
+	if lag < 0 {
+		lag = -lag
+	}
+	if lag > MaxDataAge.EventLag {
+		return fmt.Errorf("ocean event lags local clock by %v", lag.Round(time.Millisecond))
+	}
+	if otc.UpdatedAt.IsZero() {
+		return fmt.Errorf("exin quote has no timestamp")
+	}
+	if age := now.Sub(otc.UpdatedAt); age > MaxDataAge.QuoteAge {
+		return fmt.Errorf("exin quote is %v old", age.Round(time.Millisecond))
+	}
+	return nil
+}
+
+//交易对最近一次因数据过期被跳过的原因
+func (ant *Ant) StaleReason(base, quote string) string {
+	return ant.stale.get(base + "-" + quote)
+}
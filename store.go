@@ -0,0 +1,281 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	storeContextKey = "store_context_key"
+
+	StoreMySQL  = "mysql"
+	StoreSQLite = "sqlite3"
+	StoreMemory = "memory"
+
+	//旧版本把订阅用户存在redis的这个集合中
+	RedisSubscribersKey = "subcriberd_user"
+)
+
+//运行中写入的所有记录：snapshot、ProfitEvent、订阅用户、checkpoint、账本和统计
+type Store interface {
+	SaveSnapshot(s *Snapshot) error
+	SnapshotExists(id string) (bool, error)
+
+	//已存在时不覆盖
+	SaveEvent(e *ProfitEvent) error
+	UpdateEvent(id string, updates map[string]interface{}) error
+	//找不到时返回nil
+	FindEvent(id string) (*ProfitEvent, error)
+	FindEventByOrder(traces ...string) (*ProfitEvent, error)
+
+	//已订阅时保留原来的偏好
+	Subscribe(user string) error
+	Unsubscribe(user string) error
+	Subscribers() ([]*Subscriber, error)
+	//未订阅时返回nil
+	FindSubscriber(user string) (*Subscriber, error)
+	SaveSubscriber(s *Subscriber) error
+
+	ReadCheckpoint(key string) (string, error)
+	WriteCheckpoint(key, value string) error
+
+	//已存在时不覆盖
+	SaveHedgeOrder(o *HedgeOrder) error
+	FindHedgeOrders(traces ...string) ([]HedgeOrder, error)
+	//已存在时不覆盖
+	SaveLedgerEntry(e *LedgerEntry) error
+	LedgerEntries(event string) ([]LedgerEntry, error)
+	//按EventId覆盖
+	SaveEventPnL(p *EventPnL) error
+	//day在[from, to)之间，格式2006-01-02
+	EventPnLs(from, to string) ([]EventPnL, error)
+	SaveUnwind(u *Unwind) error
+	SaveValuation(v *Valuation) error
+	SaveSpread(s *SpreadSample) error
+	PruneSpreads(resolution string, before time.Time) error
+	SaveAuditLog(l *AuditLog) error
+	SaveDiscrepancy(d *BalanceDiscrepancy) error
+
+	//migration和只读的统计查询使用，内存存储返回nil
+	DB() *gorm.DB
+	Close() error
+}
+
+type Checkpoint struct {
+	Key       string    `json:"key"              gorm:"type:varchar(64);primary_key"`
+	Value     string    `json:"value"            gorm:"type:varchar(255)"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Checkpoint) TableName() string {
+	return "ant_checkpoints"
+}
+
+//按driver打开存储，sqlite的dsn是文件路径，memory不需要dsn；
+//mysql和sqlite的驱动需要在main中import
+func OpenStore(driver, dsn string) (Store, error) {
+	switch driver {
+	case StoreMySQL, StoreSQLite:
+		db, err := gorm.Open(driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLStore(db), nil
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store %q", driver)
+}
+
+//把旧版本保存在redis中的订阅用户导入store，已订阅的保留原来的偏好，返回导入的人数
+func ImportRedisSubscribers(store Store, client *redis.Client) (int, error) {
+	users, err := client.SMembers(RedisSubscribersKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	for i, user := range users {
+		if err := store.Subscribe(user); err != nil {
+			return i, err
+		}
+	}
+	return len(users), nil
+}
+
+func SetStore(ctx context.Context, store Store) context.Context {
+	if db := store.DB(); db != nil {
+		ctx = SetDB(ctx, db)
+	}
+	return context.WithValue(ctx, storeContextKey, store)
+}
+
+//没有设置存储时退回到内存存储，不会panic
+var fallbackStore = NewMemoryStore()
+
+func Storage(ctx context.Context) Store {
+	if store, ok := ctx.Value(storeContextKey).(Store); ok {
+		return store
+	}
+	return fallbackStore
+}
+
+//mysql或sqlite
+type sqlStore struct {
+	db *gorm.DB
+}
+
+func NewSQLStore(db *gorm.DB) Store {
+	return &sqlStore{db: db}
+}
+
+func (s *sqlStore) SaveSnapshot(snapshot *Snapshot) error {
+	return s.db.FirstOrCreate(snapshot).Error
+}
+
+func (s *sqlStore) SnapshotExists(id string) (bool, error) {
+	var count int
+	err := s.db.Model(&Snapshot{}).Where("snapshot_id=?", id).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *sqlStore) SaveEvent(e *ProfitEvent) error {
+	return s.db.FirstOrCreate(e).Error
+}
+
+func (s *sqlStore) UpdateEvent(id string, updates map[string]interface{}) error {
+	return s.db.Model(&ProfitEvent{}).Where("id=?", id).Updates(updates).Error
+}
+
+func (s *sqlStore) FindEvent(id string) (*ProfitEvent, error) {
+	var event ProfitEvent
+	if err := s.db.Where("id=?", id).First(&event).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (s *sqlStore) FindEventByOrder(traces ...string) (*ProfitEvent, error) {
+	var event ProfitEvent
+	if err := s.db.Where("exchange_order IN (?)", traces).First(&event).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (s *sqlStore) Subscribe(user string) error {
+	return s.db.Where(Subscriber{UserId: user}).Attrs(NewSubscriber(user)).FirstOrCreate(&Subscriber{}).Error
+}
+
+func (s *sqlStore) Unsubscribe(user string) error {
+	return s.db.Where("user_id=?", user).Delete(&Subscriber{}).Error
+}
+
+func (s *sqlStore) Subscribers() ([]*Subscriber, error) {
+	var subscribers []*Subscriber
+	if err := s.db.Find(&subscribers).Error; err != nil {
+		return nil, err
+	}
+	return subscribers, nil
+}
+
+func (s *sqlStore) FindSubscriber(user string) (*Subscriber, error) {
+	var subscriber Subscriber
+	if err := s.db.Where("user_id=?", user).First(&subscriber).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &subscriber, nil
+}
+
+func (s *sqlStore) SaveSubscriber(subscriber *Subscriber) error {
+	return s.db.Save(subscriber).Error
+}
+
+func (s *sqlStore) ReadCheckpoint(key string) (string, error) {
+	var checkpoint Checkpoint
+	if err := s.db.Where("`key`=?", key).First(&checkpoint).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return checkpoint.Value, nil
+}
+
+func (s *sqlStore) WriteCheckpoint(key, value string) error {
+	checkpoint := Checkpoint{Key: key, Value: value, UpdatedAt: time.Now()}
+	return s.db.Save(&checkpoint).Error
+}
+
+func (s *sqlStore) SaveHedgeOrder(o *HedgeOrder) error {
+	return s.db.FirstOrCreate(o).Error
+}
+
+func (s *sqlStore) FindHedgeOrders(traces ...string) ([]HedgeOrder, error) {
+	var orders []HedgeOrder
+	err := s.db.Where("trace_id IN (?)", traces).Find(&orders).Error
+	return orders, err
+}
+
+func (s *sqlStore) SaveLedgerEntry(e *LedgerEntry) error {
+	return s.db.FirstOrCreate(e).Error
+}
+
+func (s *sqlStore) LedgerEntries(event string) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := s.db.Where("event_id=?", event).Find(&entries).Error
+	return entries, err
+}
+
+func (s *sqlStore) SaveEventPnL(p *EventPnL) error {
+	return s.db.Where(EventPnL{EventId: p.EventId}).Assign(*p).FirstOrCreate(&EventPnL{}).Error
+}
+
+func (s *sqlStore) EventPnLs(from, to string) ([]EventPnL, error) {
+	var pnls []EventPnL
+	err := s.db.Where("day >= ? AND day < ?", from, to).Find(&pnls).Error
+	return pnls, err
+}
+
+func (s *sqlStore) SaveUnwind(u *Unwind) error {
+	return s.db.Create(u).Error
+}
+
+func (s *sqlStore) SaveValuation(v *Valuation) error {
+	return s.db.Create(v).Error
+}
+
+func (s *sqlStore) SaveSpread(sample *SpreadSample) error {
+	return s.db.Create(sample).Error
+}
+
+func (s *sqlStore) PruneSpreads(resolution string, before time.Time) error {
+	return s.db.Where("resolution=? AND created_at < ?", resolution, before).Delete(&SpreadSample{}).Error
+}
+
+func (s *sqlStore) SaveAuditLog(l *AuditLog) error {
+	return s.db.Create(l).Error
+}
+
+func (s *sqlStore) SaveDiscrepancy(d *BalanceDiscrepancy) error {
+	return s.db.Create(d).Error
+}
+
+func (s *sqlStore) DB() *gorm.DB {
+	return s.db
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,201 @@
+package ant
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//内存存储和sql存储的行为要一致
+func testStores(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run(StoreMemory, func(t *testing.T) {
+		fn(t, NewMemoryStore())
+	})
+	t.Run(StoreSQLite, func(t *testing.T) {
+		db := openTestDB(t)
+		if err := Migrate(db, -1); err != nil {
+			t.Fatal(err)
+		}
+		fn(t, NewSQLStore(db))
+	})
+}
+
+func TestStoreSnapshots(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		s := &Snapshot{SnapshotId: UuidWithString("snapshot"), Amount: "1", CreatedAt: time.Now()}
+		if ok, err := store.SnapshotExists(s.SnapshotId); err != nil || ok {
+			t.Fatalf("exists before save: %v %v", ok, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := store.SaveSnapshot(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ok, err := store.SnapshotExists(s.SnapshotId); err != nil || !ok {
+			t.Fatalf("exists after save: %v %v", ok, err)
+		}
+	})
+}
+
+func TestStoreEvents(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		if e, err := store.FindEvent(UuidWithString("missing")); err != nil || e != nil {
+			t.Fatalf("missing event: %v %v", e, err)
+		}
+
+		event := &ProfitEvent{
+			ID:            UuidWithString("event"),
+			Category:      PageSideBid,
+			Price:         dec("10000"),
+			Amount:        dec("0.01"),
+			BaseAmount:    decimal.Zero,
+			QuoteAmount:   decimal.Zero,
+			ExchangeOrder: UuidWithString("event" + OceanCore),
+			CreatedAt:     time.Now(),
+		}
+		if err := store.SaveEvent(event); err != nil {
+			t.Fatal(err)
+		}
+		//已存在时不覆盖
+		again := *event
+		again.Price = dec("20000")
+		if err := store.SaveEvent(&again); err != nil {
+			t.Fatal(err)
+		}
+		updates := map[string]interface{}{"base_amount": dec("0.01"), "quote_amount": dec("-100"), "otc_order": "otc"}
+		if err := store.UpdateEvent(event.ID, updates); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := store.FindEvent(event.ID)
+		if err != nil || found == nil {
+			t.Fatalf("find event: %v %v", found, err)
+		}
+		if !found.Price.Equal(dec("10000")) || !found.BaseAmount.Equal(dec("0.01")) || !found.QuoteAmount.Equal(dec("-100")) || found.OtcOrder != "otc" {
+			t.Fatalf("found event %+v", found)
+		}
+		byOrder, err := store.FindEventByOrder(UuidWithString("other"), event.ExchangeOrder)
+		if err != nil || byOrder == nil || byOrder.ID != event.ID {
+			t.Fatalf("find by order: %v %v", byOrder, err)
+		}
+		if e, err := store.FindEventByOrder(UuidWithString("other")); err != nil || e != nil {
+			t.Fatalf("find by unknown order: %v %v", e, err)
+		}
+	})
+}
+
+func TestStoreSubscribers(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		user := UuidWithString("user")
+		if s, err := store.FindSubscriber(user); err != nil || s != nil {
+			t.Fatalf("missing subscriber: %v %v", s, err)
+		}
+		if err := store.Subscribe(user); err != nil {
+			t.Fatal(err)
+		}
+		s, err := store.FindSubscriber(user)
+		if err != nil || s == nil {
+			t.Fatalf("find subscriber: %v %v", s, err)
+		}
+		if err := s.Set("profit", []string{"1.5"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveSubscriber(s); err != nil {
+			t.Fatal(err)
+		}
+		//再次订阅保留原来的偏好
+		if err := store.Subscribe(user); err != nil {
+			t.Fatal(err)
+		}
+		subscribers, err := store.Subscribers()
+		if err != nil || len(subscribers) != 1 {
+			t.Fatalf("subscribers: %v %v", subscribers, err)
+		}
+		if !subscribers[0].MinProfit.Equal(s.MinProfit) {
+			t.Fatalf("min profit %v, want %v", subscribers[0].MinProfit, s.MinProfit)
+		}
+
+		if err := store.Unsubscribe(user); err != nil {
+			t.Fatal(err)
+		}
+		if s, err := store.FindSubscriber(user); err != nil || s != nil {
+			t.Fatalf("unsubscribed: %v %v", s, err)
+		}
+	})
+}
+
+func TestStoreCheckpoints(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		if v, err := store.ReadCheckpoint("snapshots"); err != nil || v != "" {
+			t.Fatalf("missing checkpoint: %q %v", v, err)
+		}
+		for _, value := range []string{"first", "second"} {
+			if err := store.WriteCheckpoint("snapshots", value); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := store.ReadCheckpoint("snapshots"); err != nil || v != value {
+				t.Fatalf("checkpoint %q %v, want %q", v, err, value)
+			}
+		}
+	})
+}
+
+func TestStoreRecords(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		order := &HedgeOrder{ID: UuidWithString("order"), TraceId: UuidWithString("trace"), EventId: UuidWithString("event"), Amount: dec("1"), Price: dec("10000"), CreatedAt: time.Now()}
+		for i := 0; i < 2; i++ {
+			if err := store.SaveHedgeOrder(order); err != nil {
+				t.Fatal(err)
+			}
+		}
+		orders, err := store.FindHedgeOrders(UuidWithString("other"), order.TraceId)
+		if err != nil || len(orders) != 1 || orders[0].EventId != order.EventId {
+			t.Fatalf("hedge orders: %v %v", orders, err)
+		}
+
+		entry := &LedgerEntry{ID: UuidWithString("entry"), EventId: order.EventId, Amount: dec("-1"), Fee: decimal.Zero, CreatedAt: time.Now()}
+		for i := 0; i < 2; i++ {
+			if err := store.SaveLedgerEntry(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		entries, err := store.LedgerEntries(order.EventId)
+		if err != nil || len(entries) != 1 || !entries[0].Amount.Equal(dec("-1")) {
+			t.Fatalf("ledger entries: %v %v", entries, err)
+		}
+
+		//同一个event的盈亏覆盖原来的
+		for _, value := range []string{"1", "2"} {
+			pnl := &EventPnL{EventId: order.EventId, Day: "2026-10-19", BaseAmount: decimal.Zero, QuoteAmount: decimal.Zero, Fee: decimal.Zero, PnL: dec(value), Reference: dec(value)}
+			if err := store.SaveEventPnL(pnl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pnls, err := store.EventPnLs("2026-10-19", "2026-10-20")
+		if err != nil || len(pnls) != 1 || !pnls[0].PnL.Equal(dec("2")) {
+			t.Fatalf("pnls: %v %v", pnls, err)
+		}
+		if pnls, err := store.EventPnLs("2026-10-20", "2026-10-21"); err != nil || len(pnls) != 0 {
+			t.Fatalf("pnls out of range: %v %v", pnls, err)
+		}
+
+		if err := store.SaveSpread(&SpreadSample{ID: UuidWithString("spread"), Resolution: SpreadRaw, CreatedAt: time.Now().Add(-time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.PruneSpreads(SpreadRaw, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveAuditLog(&AuditLog{ID: UuidWithString("audit"), CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+//没有设置存储时不会panic
+func TestStorageFallback(t *testing.T) {
+	if err := Storage(context.Background()).WriteCheckpoint("fallback", "1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,231 @@
+package ant
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//订阅用户和推送偏好，Pairs和Side为空时不过滤；MinProfit是百分比，MinAmount以ReferenceAsset计；
+//免打扰时段是Timezone的当地时间，可以跨过零点
+type Subscriber struct {
+	UserId     string          `json:"user_id"          gorm:"type:varchar(36);primary_key"`
+	Pairs      string          `json:"pairs"            gorm:"type:varchar(255)"`
+	Side       string          `json:"side"             gorm:"type:varchar(10)"`
+	MinProfit  decimal.Decimal `json:"min_profit"       gorm:"type:varchar(36)"`
+	MinAmount  decimal.Decimal `json:"min_amount"       gorm:"type:varchar(36)"`
+	QuietStart string          `json:"quiet_start"      gorm:"type:varchar(5)"`
+	QuietEnd   string          `json:"quiet_end"        gorm:"type:varchar(5)"`
+	Timezone   string          `json:"timezone"         gorm:"type:varchar(64)"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func (Subscriber) TableName() string {
+	return "ant_subscribers"
+}
+
+func NewSubscriber(user string) *Subscriber {
+	return &Subscriber{
+		UserId:    user,
+		MinProfit: decimal.Zero,
+		MinAmount: decimal.Zero,
+		CreatedAt: time.Now(),
+	}
+}
+
+//订阅的交易对，如 BTC/USDT
+func (s *Subscriber) PairList() []string {
+	if s.Pairs == "" {
+		return nil
+	}
+	return strings.Split(s.Pairs, ",")
+}
+
+//value是event以参考货币计的数量，priced为false时不按数量过滤
+func (s *Subscriber) Wants(event *ProfitEvent, value decimal.Decimal, priced bool, now time.Time) bool {
+	if pairs := s.PairList(); len(pairs) > 0 {
+		pair, found := Who(event.Base)+"/"+Who(event.Quote), false
+		for _, p := range pairs {
+			if p == pair {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.Side != "" && s.Side != event.Category {
+		return false
+	}
+	//和通知里显示的一样按扣除手续费后的收益率比较
+	if NetProfit(event.Profit).Mul(decimal.NewFromFloat(100)).LessThan(s.MinProfit) {
+		return false
+	}
+	if priced && value.LessThan(s.MinAmount) {
+		return false
+	}
+	return !s.Quiet(now)
+}
+
+//当前是否在免打扰时段
+func (s *Subscriber) Quiet(now time.Time) bool {
+	if s.QuietStart == "" || s.QuietEnd == "" {
+		return false
+	}
+	start, err := parseClock(s.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(s.QuietEnd)
+	if err != nil {
+		return false
+	}
+	zone, err := loadZone(s.Timezone)
+	if err != nil {
+		zone = time.UTC
+	}
+	local := now.In(zone)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func (s *Subscriber) String() string {
+	pairs := "all"
+	if s.Pairs != "" {
+		pairs = strings.Replace(s.Pairs, ",", " ", -1)
+	}
+	side := "all"
+	if s.Side == PageSideBid {
+		side = "buy"
+	} else if s.Side == PageSideAsk {
+		side = "sell"
+	}
+	quiet := "off"
+	if s.QuietStart != "" {
+		zone := s.Timezone
+		if zone == "" {
+			zone = "UTC"
+		}
+		quiet = fmt.Sprintf("%s-%s %s", s.QuietStart, s.QuietEnd, zone)
+	}
+	return fmt.Sprintf("Pairs:      %s\nSide:       %s\nMin profit: %s%%\nMin amount: %s %s\nQuiet:      %s",
+		pairs, side, s.MinProfit.String(), s.MinAmount.String(), Who(ReferenceAsset), quiet)
+}
+
+//按聊天命令修改偏好，如 pairs BTC/USDT ETH/BTC、side buy、profit 1.5、amount 100、quiet 23:00-07:00 Asia/Shanghai
+func (s *Subscriber) Set(key string, values []string) error {
+	switch strings.ToLower(key) {
+	case "pairs", "pair":
+		if len(values) == 1 && strings.ToLower(values[0]) == "all" {
+			s.Pairs = ""
+			return nil
+		}
+		pairs := make([]string, 0, len(values))
+		for _, value := range values {
+			pair := strings.ToUpper(value)
+			symbols := strings.Split(pair, "/")
+			if len(symbols) != 2 || GetAssetId(symbols[0]) == "" || GetAssetId(symbols[1]) == "" {
+				return fmt.Errorf("unknown pair %s", value)
+			}
+			pairs = append(pairs, pair)
+		}
+		s.Pairs = strings.Join(pairs, ",")
+	case "side":
+		if len(values) != 1 {
+			return fmt.Errorf("side is buy, sell or all")
+		}
+		switch strings.ToLower(values[0]) {
+		case "buy":
+			s.Side = PageSideBid
+		case "sell":
+			s.Side = PageSideAsk
+		case "all":
+			s.Side = ""
+		default:
+			return fmt.Errorf("side is buy, sell or all")
+		}
+	case "profit", "amount":
+		if len(values) != 1 {
+			return fmt.Errorf("%s needs a number", key)
+		}
+		value, err := decimal.NewFromString(strings.TrimSuffix(values[0], "%"))
+		if err != nil || value.IsNegative() {
+			return fmt.Errorf("%s needs a number", key)
+		}
+		if strings.ToLower(key) == "profit" {
+			s.MinProfit = value
+		} else {
+			s.MinAmount = value
+		}
+	case "quiet":
+		if len(values) == 1 && strings.ToLower(values[0]) == "off" {
+			s.QuietStart, s.QuietEnd, s.Timezone = "", "", ""
+			return nil
+		}
+		if len(values) < 1 || len(values) > 2 {
+			return fmt.Errorf("quiet is HH:MM-HH:MM [timezone] or off")
+		}
+		clocks := strings.Split(values[0], "-")
+		if len(clocks) != 2 {
+			return fmt.Errorf("quiet is HH:MM-HH:MM [timezone] or off")
+		}
+		for _, clock := range clocks {
+			if _, err := parseClock(clock); err != nil {
+				return err
+			}
+		}
+		timezone := ""
+		if len(values) == 2 {
+			if _, err := loadZone(values[1]); err != nil {
+				return fmt.Errorf("unknown timezone %s", values[1])
+			}
+			timezone = values[1]
+		}
+		s.QuietStart, s.QuietEnd, s.Timezone = clocks[0], clocks[1], timezone
+	default:
+		return fmt.Errorf("unknown option %s", key)
+	}
+	return nil
+}
+
+//HH:MM换算成当天的分钟数
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %s, use HH:MM", clock)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, fmt.Errorf("invalid time %s, use HH:MM", clock)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("invalid time %s, use HH:MM", clock)
+	}
+	return hour*60 + minute, nil
+}
+
+//支持 Asia/Shanghai 这样的名字和 +08:00 这样的偏移
+func loadZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		offset, err := parseClock(name[1:])
+		if err != nil {
+			return nil, err
+		}
+		if name[0] == '-' {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset*60), nil
+	}
+	return time.LoadLocation(name)
+}
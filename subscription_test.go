@@ -0,0 +1,20 @@
+package ant
+
+import (
+	"testing"
+	"time"
+)
+
+//最低收益按通知里显示的扣除手续费后的收益率过滤
+func TestSubscriberWantsNetProfit(t *testing.T) {
+	event := &ProfitEvent{Category: PageSideBid, Base: BTC, Quote: USDT, Profit: dec("0.012")}
+	s := NewSubscriber("user")
+	s.MinProfit = dec("1")
+	if s.Wants(event, dec("0"), false, time.Now()) {
+		t.Fatalf("net profit %v passes min profit 1%%", NetProfit(event.Profit))
+	}
+	s.MinProfit = dec("0.5")
+	if !s.Wants(event, dec("0"), false, time.Now()) {
+		t.Fatalf("net profit %v filtered by min profit 0.5%%", NetProfit(event.Profit))
+	}
+}
@@ -0,0 +1,398 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	TriangleProfitThreshold = 0.003
+	TriangleDepth           = 10
+	TriangleSlippage        = 0.005
+	TriangleRefundWait      = 10 * time.Second
+)
+
+//每轮三角套利最多投入的资金
+var TriangleFunds = map[string]float64{
+	BTC:  0.01,
+	USDT: 100,
+}
+
+//三角套利中的一步，Side为我们在ocean.one上的挂单方向
+type Leg struct {
+	Side  string
+	Base  string
+	Quote string
+}
+
+//这一步付出的资产
+func (leg Leg) From() string {
+	if leg.Side == PageSideBid {
+		return leg.Quote
+	}
+	return leg.Base
+}
+
+//这一步得到的资产
+func (leg Leg) To() string {
+	if leg.Side == PageSideBid {
+		return leg.Base
+	}
+	return leg.Quote
+}
+
+type Cycle [3]Leg
+
+func (cycle Cycle) String() string {
+	return fmt.Sprintf("%s->%s->%s->%s", Who(cycle[0].From()), Who(cycle[1].From()), Who(cycle[2].From()), Who(cycle[2].To()))
+}
+
+//按深度逐档吃单，返回实际消耗的数量、扣除手续费后得到的数量以及最差成交价
+func Simulate(depth *Depth, side string, input decimal.Decimal) (used, output, worst decimal.Decimal) {
+	orders := depth.Bids
+	if side == PageSideBid {
+		orders = depth.Asks
+	}
+
+	left := input
+	for _, order := range orders {
+		if !left.IsPositive() {
+			break
+		}
+		worst = order.Price
+		if side == PageSideBid {
+			funds := order.Price.Mul(order.Amount)
+			if funds.GreaterThan(left) {
+				funds = left
+			}
+			output = output.Add(funds.Div(order.Price))
+			left = left.Sub(funds)
+		} else {
+			amount := order.Amount
+			if amount.GreaterThan(left) {
+				amount = left
+			}
+			output = output.Add(amount.Mul(order.Price))
+			left = left.Sub(amount)
+		}
+	}
+	used = input.Sub(left)
+	output = output.Mul(decimal.NewFromFloat(1 - OceanFee))
+	return
+}
+
+func (ant *Ant) legsFrom(asset string) []Leg {
+	legs := make([]Leg, 0)
+	for _, book := range ant.books {
+		if book.quote == asset {
+			legs = append(legs, Leg{Side: PageSideBid, Base: book.base, Quote: book.quote})
+		} else if book.base == asset {
+			legs = append(legs, Leg{Side: PageSideAsk, Base: book.base, Quote: book.quote})
+		}
+	}
+	return legs
+}
+
+//两种资产之间直接兑换的一步
+func (ant *Ant) directLeg(from, to string) (Leg, bool) {
+	for _, leg := range ant.legsFrom(from) {
+		if leg.To() == to {
+			return leg, true
+		}
+	}
+	return Leg{}, false
+}
+
+//以start为起点和终点的所有三角环路
+func (ant *Ant) FindCycles(start string) []Cycle {
+	cycles := make([]Cycle, 0)
+	for _, first := range ant.legsFrom(start) {
+		for _, second := range ant.legsFrom(first.To()) {
+			if second.To() == start {
+				continue
+			}
+			for _, third := range ant.legsFrom(second.To()) {
+				if third.To() == start {
+					cycles = append(cycles, Cycle{first, second, third})
+				}
+			}
+		}
+	}
+	return cycles
+}
+
+//按深度计算投入funds后整个环路的收益率，以及每一步的挂单价格和投入数量
+func (ant *Ant) evaluate(cycle Cycle, funds decimal.Decimal) (decimal.Decimal, []Order) {
+	depths := make([]*Depth, len(cycle))
+	for i, leg := range cycle {
+		depths[i] = ant.books[leg.Base+"-"+leg.Quote].GetDepth(TriangleDepth)
+	}
+
+	for retry := 0; retry < 3; retry++ {
+		plan := make([]Order, 0, len(cycle))
+		input := funds
+		for i, leg := range cycle {
+			used, output, worst := Simulate(depths[i], leg.Side, input)
+			if !used.IsPositive() {
+				return decimal.Zero, nil
+			}
+			//深度不足，按比例缩小投入后重新计算
+			if used.LessThan(input) {
+				funds = funds.Mul(used).Div(input)
+				plan = nil
+				break
+			}
+			plan = append(plan, Order{Price: worst, Amount: input})
+			input = output
+		}
+		if plan != nil {
+			return input.Sub(funds).Div(funds), plan
+		}
+	}
+	return decimal.Zero, nil
+}
+
+//在ocean.one内部寻找三角套利机会
+func (ant *Ant) Triangle(ctx context.Context, starts ...string) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, start := range starts {
+				funds := decimal.NewFromFloat(TriangleFunds[start])
+				ant.assetsLock.Lock()
+				balance := ant.assets[start]
+				ant.assetsLock.Unlock()
+				if ant.enableOcean && balance.LessThan(funds) {
+					funds = balance
+				}
+				if !funds.IsPositive() {
+					continue
+				}
+
+				for _, cycle := range ant.FindCycles(start) {
+					if !ant.tradable(cycle[0].From(), cycle[1].From(), cycle[2].From()) || ant.cyclePaused(cycle) {
+						continue
+					}
+					profit, plan := ant.evaluate(cycle, funds)
+					if plan == nil || profit.LessThan(decimal.NewFromFloat(TriangleProfitThreshold)) {
+						continue
+					}
+					log.Printf("triangle %s, funds: %v, profit: %v", cycle, plan[0].Amount, profit)
+					if !ant.enableOcean {
+						continue
+					}
+					if err := ant.runCycle(ctx, cycle, plan, profit); err != nil {
+						log.Println("triangle error", err)
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+func newLegEvent(cycle string, index int, strategy string, leg Leg, price, send, profit decimal.Decimal) *ProfitEvent {
+	id := UuidWithString(cycle + strconv.Itoa(index))
+	amount := send
+	if leg.Side == PageSideBid {
+		amount = send.Div(price)
+	}
+	return &ProfitEvent{
+		ID:            id,
+		Category:      leg.Side,
+		Strategy:      strategy,
+		Price:         price,
+		Amount:        amount,
+		Profit:        profit,
+		Base:          leg.Base,
+		Quote:         leg.Quote,
+		Expire:        int64(PolicyOf(strategy).Lifetime),
+		CreatedAt:     time.Now(),
+		BaseAmount:    decimal.Zero,
+		QuoteAmount:   decimal.Zero,
+		ExchangeOrder: UuidWithString(id + OceanCore),
+	}
+}
+
+//环路中有交易对被管理员暂停
+func (ant *Ant) cyclePaused(cycle Cycle) bool {
+	for _, leg := range cycle {
+		if ant.pairPaused(leg.Base, leg.Quote) {
+			return true
+		}
+	}
+	return false
+}
+
+//依次执行三步，上一步实际得到的数量作为下一步的投入
+func (ant *Ant) runCycle(ctx context.Context, cycle Cycle, plan []Order, profit decimal.Decimal) error {
+	id := uuid.Must(uuid.NewV4()).String()
+	start := cycle[0].From()
+	hold := plan[0].Amount
+	for i, leg := range cycle {
+		event := newLegEvent(id, i, StrategyTriangle, leg, plan[i].Price, hold, profit)
+		received, refund, err := ant.runLeg(ctx, event, hold, i > 0)
+		//中间资产没有全部换出，退回的部分直接换回起始资产；下单前就失败时整个hold都还在手里
+		if i > 0 {
+			left := refund
+			if err != nil {
+				left = hold
+			}
+			if left.IsPositive() {
+				ant.settleLeft(ctx, id, len(cycle)+i, event, leg.From(), start, left)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if !received.IsPositive() {
+			return fmt.Errorf("triangle %s, leg %d not filled", cycle, i)
+		}
+		hold = received
+	}
+	return nil
+}
+
+//下单后等待成交，limit单到期撤单，然后等待退款，返回得到的数量和退回的数量；
+//settlement为true时处理的是已经换出来的资产，退出过程中也会执行
+func (ant *Ant) runLeg(ctx context.Context, event *ProfitEvent, send decimal.Decimal, settlement bool) (decimal.Decimal, decimal.Decimal, error) {
+	leg := Leg{Side: event.Category, Base: event.Base, Quote: event.Quote}
+	size, err := SizeSend(leg.Side, event.Price, send)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("%s leg %s, %v", event.Strategy, event.ID, err)
+	}
+	event.Price = size.Price
+
+	policy := PolicyOf(event.Strategy)
+	p := ant.track(event, false, settlement)
+	if p == nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("%s leg %s already running or shutting down", event.Strategy, event.ID)
+	}
+	defer ant.untrack(p)
+	if policy.Type == OrderTypeLimit {
+		ant.registry.Lock()
+		p.expect = size.Receive()
+		ant.registry.Unlock()
+	}
+
+	if _, err := oceanTrade(leg.Side, policy.Price(size), size.Send.String(), policy.Type, leg.Base, leg.Quote, event.ExchangeOrder); err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	if err := Storage(ctx).SaveEvent(event); err != nil {
+		log.Println("create leg error", err)
+	}
+
+	//退出时新开的第一步立即撤单，settlement的步骤处理已有资金，按期限执行完
+	stop := ctx.Done()
+	if settlement {
+		stop = nil
+	}
+	select {
+	case <-p.closed:
+	case <-legDeadline(policy):
+		ant.expireLeg(p, policy)
+	case <-stop:
+		ant.expireLeg(p, policy)
+	}
+
+	ant.registry.Lock()
+	received, refund := p.received, p.refund
+	updates := map[string]interface{}{"base_amount": event.BaseAmount, "quote_amount": event.QuoteAmount}
+	ant.registry.Unlock()
+	if err := Storage(ctx).UpdateEvent(event.ID, updates); err != nil {
+		log.Println("update leg error", err)
+	}
+	return received, refund, nil
+}
+
+//limit单到期后撤单，市价单等待退款
+func legDeadline(policy OrderPolicy) <-chan time.Time {
+	if policy.Type != OrderTypeLimit {
+		return time.After(TriangleRefundWait)
+	}
+	return time.After(policy.Lifetime)
+}
+
+//撤掉limit单，最多再等TriangleRefundWait的退款
+func (ant *Ant) expireLeg(p *position, policy OrderPolicy) {
+	if policy.Type == OrderTypeLimit {
+		ant.cancel(p)
+	}
+	select {
+	case <-p.closed:
+	case <-time.After(TriangleRefundWait):
+	}
+}
+
+//把换回的中间资产和forceUnwind一样记下来并告警，金额都以to计：
+//Cost是卖出的部分按盘口价格值多少，Returned是实际换回的数量，Stuck是没有换出去的from
+func (ant *Ant) settleLeft(ctx context.Context, cycle string, index int, event *ProfitEvent, from, to string, amount decimal.Decimal) {
+	received, stuck, leg, price, err := ant.unwind(ctx, cycle, index, from, to, amount)
+	if err != nil {
+		log.Println("triangle unwind error", err)
+	}
+
+	cost := decimal.Zero
+	if sold := amount.Sub(stuck); sold.IsPositive() && price.IsPositive() {
+		cost = sold.Mul(price)
+		if leg.Side == PageSideBid {
+			cost = sold.Div(price)
+		}
+	}
+	unwind := Unwind{
+		ID:        UuidWithString(cycle + strconv.Itoa(index) + "unwind"),
+		EventId:   event.ID,
+		Route:     Who(from) + ">" + Who(to),
+		Asset:     to,
+		Amount:    received,
+		Cost:      cost,
+		Returned:  received,
+		Loss:      cost.Sub(received),
+		Stuck:     stuck,
+		CreatedAt: time.Now(),
+	}
+	if err := Storage(ctx).SaveUnwind(&unwind); err != nil {
+		log.Println("create unwind error", err)
+	}
+	msg := fmt.Sprintf("triangle %s unwind %v %s, returned %v of %v, loss %v %s", event.ID, amount, Who(from), received, cost, unwind.Loss, Who(to))
+	if stuck.IsPositive() {
+		msg += fmt.Sprintf(", %v %s stuck", stuck, Who(from))
+	}
+	ant.Alert(ctx, msg)
+}
+
+//把持有的中间资产直接换回起始资产，返回换回的数量、没有换出去的数量，以及使用的交易对和盘口价格
+func (ant *Ant) unwind(ctx context.Context, cycle string, index int, from, to string, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal, Leg, decimal.Decimal, error) {
+	leg, ok := ant.directLeg(from, to)
+	if !ok {
+		return decimal.Zero, amount, leg, decimal.Zero, fmt.Errorf("no market for %s/%s", Who(from), Who(to))
+	}
+
+	depth := ant.books[leg.Base+"-"+leg.Quote].GetDepth(TriangleDepth)
+	_, _, worst := Simulate(depth, leg.Side, amount)
+	if !worst.IsPositive() {
+		return decimal.Zero, amount, leg, decimal.Zero, fmt.Errorf("empty book for %s/%s", Who(leg.Base), Who(leg.Quote))
+	}
+
+	slippage := decimal.NewFromFloat(TriangleSlippage)
+	price := worst.Mul(decimal.NewFromFloat(1).Add(slippage))
+	if leg.Side == PageSideAsk {
+		price = worst.Mul(decimal.NewFromFloat(1).Sub(slippage))
+	}
+	event := newLegEvent(cycle, index, StrategyTriangle, leg, price, amount, decimal.Zero)
+	received, refund, err := ant.runLeg(ctx, event, amount, true)
+	if err != nil {
+		return decimal.Zero, amount, leg, worst, err
+	}
+	return received, refund, leg, worst, nil
+}
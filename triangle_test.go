@@ -0,0 +1,57 @@
+package ant
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//所有订单都登记在registry中，不撤单的limit单会在过期后丢失后续的成交
+func TestOrderPolicyLifetime(t *testing.T) {
+	if _, _, err := ParseOrderPolicy(StrategyArbitrage + "=limit:0s"); err == nil {
+		t.Fatal("limit order without lifetime accepted")
+	}
+	if _, policy, err := ParseOrderPolicy(StrategyTriangle + "=limit:2s"); err != nil || policy.Lifetime != 2*time.Second {
+		t.Fatalf("limit:2s parsed as %+v %v", policy, err)
+	}
+
+	previous := OrderPolicies[StrategyTriangle]
+	defer func() { OrderPolicies[StrategyTriangle] = previous }()
+	OrderPolicies[StrategyTriangle] = OrderPolicy{Type: OrderTypeLimit}
+	if lifetime := PolicyOf(StrategyTriangle).Lifetime; lifetime != time.Duration(OrderExpireTime) {
+		t.Fatalf("zero lifetime used as %v", lifetime)
+	}
+}
+
+//limit单全部成交后不会再有退款，收到的数量够了就结束这一步
+func TestLegFilledCloses(t *testing.T) {
+	ctx := SetStore(context.Background(), NewMemoryStore())
+	ant := NewAnt(false, false)
+	leg := Leg{Side: PageSideBid, Base: BTC, Quote: USDT}
+	event := newLegEvent("filled", 0, StrategyTriangle, leg, dec("10000"), dec("100"), dec("0"))
+	size, err := SizeSend(leg.Side, event.Price, dec("100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := ant.track(event, false, false)
+	p.expect = size.Receive()
+
+	half := size.Receive().Div(dec("2"))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-p.closed:
+			t.Fatal("leg closed before it is filled")
+		default:
+		}
+		s := &Snapshot{SnapshotId: UuidWithString("fill" + string(rune('0'+i))), Amount: half.String(), TraceId: event.ExchangeOrder, OpponentId: OceanCore, ReplyType: ReplyMatch}
+		s.AssetId = BTC
+		if err := ant.HandleSnapshot(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-p.closed:
+	default:
+		t.Fatal("filled leg still waiting for a refund")
+	}
+}